@@ -4,19 +4,31 @@ package CryoDecoder
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"maps"
 	"math"
+	"math/big"
+	"net"
+	"net/netip"
 	"reflect"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // BOF and EOF are markers that frame a complete object in the binary stream.
 const (
 	BOF = 0xAB // Beginning of Frame
 	EOF = 0xCD // End of Frame
+
+	// BOFHeader begins a versioned frame: the marker is followed by a format version
+	// byte and a FrameFlags byte, then the same tag, length, payload and EOF as a
+	// legacy frame.
+	BOFHeader = 0xAC
 )
 
 // Codec defines the interface for any type that can encode and decode a specific data type.
@@ -31,17 +43,138 @@ type CodecRegistry struct {
 	codecs        map[byte]Codec
 	types         map[reflect.Type]byte
 	nextStructTag byte // To auto-generate unique tags for structs
+
+	marshalerOrder []MarshalerKind // Fallback interfaces tried by resolveType, in order
+	opts           *decodeOptions  // Shared with the codecs the registry creates
+
+	typeIDs map[byte]uint64 // Cache for typeID, cleared whenever a tag changes
 }
 
+// firstStructTag is the first tag assigned to structs and other resolved types.
+// Tags 0-199 are reserved for primitives.
+const firstStructTag = 200
+
 // NewCodecRegistry creates and returns an empty CodecRegistry.
 func NewCodecRegistry() *CodecRegistry {
 	return &CodecRegistry{
 		codecs:        make(map[byte]Codec),
 		types:         make(map[reflect.Type]byte),
-		nextStructTag: 200, // Reserve tags 0-199 for primitives, start struct tags at 200
+		nextStructTag: firstStructTag,
+
+		marshalerOrder: DefaultMarshalerOrder(),
+		opts:           &decodeOptions{},
 	}
 }
 
+// clone returns a registry with the same codecs, types, options and next struct tag
+// as r. Types resolved on the clone are not added to r. Codecs registered before the
+// clone keep looking up tags in r, except the interface{} and map[string]interface{}
+// codecs, which resolve values at encode time and are rebound to the clone.
+func (r *CodecRegistry) clone() *CodecRegistry {
+	c := &CodecRegistry{
+		codecs:        maps.Clone(r.codecs),
+		types:         maps.Clone(r.types),
+		nextStructTag: r.nextStructTag,
+
+		marshalerOrder: r.marshalerOrder,
+		opts:           r.opts,
+	}
+	for tag, codec := range c.codecs {
+		switch codec.(type) {
+		case *InterfaceCodec:
+			c.codecs[tag] = &InterfaceCodec{registry: c}
+		case *MapStringAnyCodec:
+			c.codecs[tag] = &MapStringAnyCodec{registry: c}
+		}
+	}
+	return c
+}
+
+// decodeOptions controls how strictly codecs validate their input. A registry shares
+// one instance with every codec it creates, so changes apply to codecs registered
+// earlier too. A nil *decodeOptions means the defaults.
+type decodeOptions struct {
+	strict        bool
+	allowTrailing bool
+}
+
+func (o *decodeOptions) isStrict() bool {
+	return o != nil && o.strict
+}
+
+// codecOptions is embedded by codecs whose decoding depends on the registry's
+// settings. The registry sets it when the codec is registered.
+type codecOptions struct {
+	opts *decodeOptions
+}
+
+func (c *codecOptions) setOptions(opts *decodeOptions) {
+	c.opts = opts
+}
+
+// optionsSetter is implemented by codecs that embed codecOptions.
+type optionsSetter interface {
+	setOptions(opts *decodeOptions)
+}
+
+// checkTrailing reports an error if a composite payload was not fully consumed,
+// unless trailing bytes were explicitly allowed outside strict mode.
+func (o *decodeOptions) checkTrailing(remaining int, what string) error {
+	if remaining != 0 && (o == nil || o.strict || !o.allowTrailing) {
+		return fmt.Errorf("invalid %s data: %d trailing bytes", what, remaining)
+	}
+	return nil
+}
+
+// checkUvarint reports an error in strict mode if the n bytes a uvarint was read from
+// are longer than the minimal encoding of v.
+func (o *decodeOptions) checkUvarint(v uint64, n int, what string) error {
+	if o.isStrict() {
+		var buf [binary.MaxVarintLen64]byte
+		if minimal := binary.PutUvarint(buf[:], v); n != minimal {
+			return fmt.Errorf("non-minimal %s encoding: %d bytes instead of %d", what, n, minimal)
+		}
+	}
+	return nil
+}
+
+// checkVarint is checkUvarint for signed varints.
+func (o *decodeOptions) checkVarint(v int64, n int, what string) error {
+	if o.isStrict() {
+		var buf [binary.MaxVarintLen64]byte
+		if minimal := binary.PutVarint(buf[:], v); n != minimal {
+			return fmt.Errorf("non-minimal %s encoding: %d bytes instead of %d", what, n, minimal)
+		}
+	}
+	return nil
+}
+
+// checkPresence reports an error in strict mode if the presence byte of an optional
+// value is neither 0 nor 1.
+func (o *decodeOptions) checkPresence(b byte, what string) error {
+	if b > 1 && o.isStrict() {
+		return fmt.Errorf("invalid %s presence byte %d: expected 0 or 1", what, b)
+	}
+	return nil
+}
+
+// SetStrict enables or disables strict decoding. In strict mode only the canonical
+// encoding of a value is accepted: booleans and presence bytes must be 0 or 1, strings
+// must be valid UTF-8, composite payloads must not contain trailing bytes, maps and
+// sets must not repeat keys, and lengths and varints must use their shortest form. This matters for signed or
+// hashed messages, where two encodings of the same value must not both verify.
+func (r *CodecRegistry) SetStrict(strict bool) {
+	r.opts.strict = strict
+}
+
+// AllowTrailingBytes controls whether struct, collection and interface payloads may
+// contain bytes after their last expected element. They are rejected by default; allow
+// them when peers may append fields this registry does not know about yet. Strict mode
+// always rejects them.
+func (r *CodecRegistry) AllowTrailingBytes(allow bool) {
+	r.opts.allowTrailing = allow
+}
+
 // RegisterPrimitives is a convenience method to register the built-in primitive codecs.
 // MODIFIED: Added tags 20 (time.Location) and updated interface/map tags.
 func (r *CodecRegistry) RegisterPrimitives() {
@@ -62,11 +195,53 @@ func (r *CodecRegistry) RegisterPrimitives() {
 	r.RegisterCodec(15, &Float32Codec{}, float32(0))
 	r.RegisterCodec(16, &Complex64Codec{}, complex64(0))
 	r.RegisterCodec(17, &Complex128Codec{}, complex128(0))
-	r.RegisterCodec(18, &InterfaceCodec{registry: r}, []any{}) // interface{}
+	anyCodec := &InterfaceCodec{registry: r}
+	r.RegisterCodec(18, anyCodec, []any{}) // interface{}
+	r.registerType(18, anyCodec, reflect.TypeOf((*any)(nil)).Elem())
 	r.RegisterCodec(19, &MapStringAnyCodec{registry: r}, map[string]interface{}(nil))
 
-	// NEW: Register time.Location specifically
-	r.RegisterCodec(20, &LocationCodec{}, time.Location{})
+	// NEW: Register time.Location specifically. Locations are almost always held by pointer;
+	// time.Location values get a by-value LocationCodec on demand in resolveType.
+	r.RegisterCodec(20, &LocationCodec{}, (*time.Location)(nil))
+
+	// Compact time codecs. Re-register tag 21 with NewTimeCodec to change precision.
+	r.RegisterCodec(21, NewTimeCodec(TimeNanoseconds, false), time.Time{})
+	r.RegisterCodec(22, &DurationCodec{}, time.Duration(0))
+
+	// Arbitrary-precision numbers
+	r.RegisterCodec(23, &BigIntCodec{}, (*big.Int)(nil))
+	r.RegisterCodec(24, &BigFloatCodec{}, (*big.Float)(nil))
+	r.RegisterCodec(25, &BigRatCodec{}, (*big.Rat)(nil))
+	r.RegisterCodec(26, &DecimalCodec{}, Decimal{})
+
+	// Network addresses and identifiers
+	r.RegisterCodec(27, &NetipAddrCodec{}, netip.Addr{})
+	r.RegisterCodec(28, &NetipAddrPortCodec{}, netip.AddrPort{})
+	r.RegisterCodec(29, &NetipPrefixCodec{}, netip.Prefix{})
+	r.RegisterCodec(30, &NetIPCodec{}, net.IP(nil))
+	r.RegisterCodec(31, &NetHardwareAddrCodec{}, net.HardwareAddr(nil))
+	r.RegisterCodec(32, &UUIDCodec{}, UUID{})
+
+	// Bit-packed collections
+	r.RegisterCodec(41, &BoolSliceCodec{}, []bool(nil))
+	r.RegisterCodec(42, &BitsetCodec{}, Bitset{})
+
+	// database/sql nullable column types. Optional[T] and sql.Null[T] are resolved on demand.
+	r.registerNullable(33, sql.NullString{})
+	r.registerNullable(34, sql.NullInt64{})
+	r.registerNullable(35, sql.NullInt32{})
+	r.registerNullable(36, sql.NullInt16{})
+	r.registerNullable(37, sql.NullByte{})
+	r.registerNullable(38, sql.NullFloat64{})
+	r.registerNullable(39, sql.NullBool{})
+	r.registerNullable(40, sql.NullTime{})
+
+	// Registry schema, so peers can exchange it before anything else
+	r.RegisterCodec(43, &SchemaCodec{}, Schema{})
+	r.RegisterCodec(44, &HelloCodec{}, Hello{})
+	r.RegisterCodec(45, &rpcMessageCodec{}, rpcMessage{})
+	r.RegisterCodec(46, &streamFrameCodec{}, streamFrame{})
+	r.RegisterCodec(47, &controlFrameCodec{}, controlFrame{})
 }
 
 // resolveType finds or creates a codec for the given reflect.Type.
@@ -146,7 +321,26 @@ func (r *CodecRegistry) resolveType(t reflect.Type) (reflect.Type, byte, error)
 		return t, arrayTag, nil
 	}
 
-	// 5. Handle Maps recursively
+	// 5. Handle Sets (map[K]struct{}) and Maps recursively
+	if isSetType(t) {
+		keyType, keyTag, err := r.resolveType(t.Key())
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to resolve set key type %v: %w", t.Key(), err)
+		}
+
+		keyCodec, err := r.GetCodec(keyTag)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		setTag := r.nextStructTag
+		r.nextStructTag++
+
+		setZero := reflect.MakeMap(t).Interface()
+
+		r.RegisterCodec(setTag, &SetCodec{keyCodec: keyCodec, keyType: keyType, setType: t}, setZero)
+		return t, setTag, nil
+	}
 	if t.Kind() == reflect.Map {
 		keyType, keyTag, err := r.resolveType(t.Key())
 		if err != nil {
@@ -178,26 +372,67 @@ func (r *CodecRegistry) resolveType(t reflect.Type) (reflect.Type, byte, error)
 		return t, mapTag, nil
 	}
 
-	// 6. Handle specific known types (e.g. time.Location) that we can't introspect
+	// 6. Handle Optional[T] and sql.Null types: a value field plus a Valid flag
+	if isNullableType(t) {
+		codec, err := newNullableCodec(r, t)
+		if err != nil {
+			return nil, 0, err
+		}
+		nullTag := r.nextStructTag
+		r.nextStructTag++
+		r.RegisterCodec(nullTag, codec, reflect.New(t).Elem().Interface())
+		return t, nullTag, nil
+	}
+
+	// 7. Non-empty interfaces need their implementations registered up front
+	if t.Kind() == reflect.Interface {
+		return nil, 0, fmt.Errorf("interface type %v has no registered implementations; use RegisterInterface", t)
+	}
+
+	// 8. Handle specific known types (e.g. time.Location) that we can't introspect
 	if t.PkgPath() == "time" && t.Name() == "Location" {
 		locTag := r.nextStructTag
 		r.nextStructTag++
-		r.RegisterCodec(locTag, &LocationCodec{}, reflect.New(t).Elem().Interface())
+		r.RegisterCodec(locTag, &LocationCodec{byValue: true}, reflect.New(t).Elem().Interface())
 		return t, locTag, nil
 	}
 
-	// 7. Check for BinaryMarshaler (for other built-in types)
-	if t.Implements(reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()) {
-		marshalTag := r.nextStructTag
-		r.nextStructTag++
-		zeroValue := reflect.New(t).Elem().Interface()
-		r.RegisterCodec(marshalTag, &MarshalerCodec{typ: t}, zeroValue)
+	// 9. Fall back to marshaling interfaces (for other built-in and third-party types).
+	// Structs only try BinaryMarshaler here, as they always have; the other kinds are
+	// tried after native encoding, so adding a method such as MarshalJSON to a struct
+	// that encodes natively does not change its wire format.
+	early, late := r.marshalerOrder, []MarshalerKind(nil)
+	if t.Kind() == reflect.Struct {
+		early, late = splitMarshalerOrder(r.marshalerOrder)
+	}
+	if marshalTag, ok, err := r.resolveMarshaler(t, early); err != nil {
+		return nil, 0, err
+	} else if ok {
 		return t, marshalTag, nil
 	}
 
-	// 8. Handle Structs (Recursion)
+	// 10. Handle Structs (Recursion)
 	if t.Kind() == reflect.Struct {
 		zeroValue := reflect.New(t).Elem().Interface()
+		var structErr error
+		if !hasUnexportedFields(t) {
+			structTag, err := r.RegisterStruct(zeroValue)
+			if err == nil {
+				return t, structTag, nil
+			}
+			structErr = err
+		}
+
+		// 11. Structs that cannot be encoded natively try the remaining marshalers
+		if marshalTag, ok, err := r.resolveMarshaler(t, late); err != nil {
+			return nil, 0, err
+		} else if ok {
+			return t, marshalTag, nil
+		}
+		if structErr != nil {
+			return nil, 0, structErr
+		}
+
 		structTag, err := r.RegisterStruct(zeroValue)
 		if err != nil {
 			return nil, 0, err
@@ -208,6 +443,29 @@ func (r *CodecRegistry) resolveType(t reflect.Type) (reflect.Type, byte, error)
 	return nil, 0, fmt.Errorf("no codec found for type %v", t)
 }
 
+// resolveMarshaler registers a codec for the first of kinds that t implements and
+// reports whether it found one.
+func (r *CodecRegistry) resolveMarshaler(t reflect.Type, kinds []MarshalerKind) (byte, bool, error) {
+	codec, err := marshalerCodec(t, kinds)
+	if err != nil || codec == nil {
+		return 0, false, err
+	}
+	marshalTag := r.nextStructTag
+	r.nextStructTag++
+	r.RegisterCodec(marshalTag, codec, reflect.New(t).Elem().Interface())
+	return marshalTag, true, nil
+}
+
+// hasUnexportedFields reports whether t has fields that StructCodec cannot reach.
+func hasUnexportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
 // RegisterStruct automatically registers a custom struct and all of its nested structs.
 // MODIFIED: Uses resolveType to handle pointers, nested structs, and specific types automatically.
 func (r *CodecRegistry) RegisterStruct(exampleType interface{}) (byte, error) {
@@ -249,9 +507,21 @@ func (r *CodecRegistry) RegisterStruct(exampleType interface{}) (byte, error) {
 }
 
 // RegisterCodec is a low-level method to associate a tag with a Codec and a Go type.
+// Built-in codecs such as a TimeCodec from NewTimeCodec follow the registry's strict
+// mode once registered.
 func (r *CodecRegistry) RegisterCodec(tag byte, codec Codec, exampleType interface{}) {
+	r.registerType(tag, codec, reflect.TypeOf(exampleType))
+}
+
+// registerType associates a tag with a Codec and a reflect.Type.
+// It is used for types that cannot be expressed as an example value, such as interfaces.
+func (r *CodecRegistry) registerType(tag byte, codec Codec, t reflect.Type) {
+	if c, ok := codec.(optionsSetter); ok {
+		c.setOptions(r.opts)
+	}
 	r.codecs[tag] = codec
-	r.types[reflect.TypeOf(exampleType)] = tag
+	r.types[t] = tag
+	r.typeIDs = nil
 }
 
 // GetCodec retrieves the Codec associated with a given tag.
@@ -286,18 +556,29 @@ func (r *CodecRegistry) GetTag(value interface{}) (byte, error) {
 type Encoder struct {
 	registry *CodecRegistry
 	buffer   *bytes.Buffer
+	header   bool // Write versioned frames (BOFHeader) instead of legacy ones
+
+	compressor        Compressor // Compresses payloads of at least compressThreshold bytes
+	compressThreshold int
+	keyring           *Keyring // Seals payloads when set
+	macKeyID          byte     // Signs frames when macKey is set
+	macKey            []byte
+	session           []byte // Bound into every MAC; see SetSession
+	sequence          uint64 // Sequence number of the last signed frame
 }
 
 func NewEncoder(registry *CodecRegistry) *Encoder {
 	return &Encoder{registry: registry, buffer: &bytes.Buffer{}}
 }
 
-func (e *Encoder) Encode(value interface{}) ([]byte, error) {
-	e.buffer.Reset()
-	if err := e.buffer.WriteByte(BOF); err != nil {
-		return nil, fmt.Errorf("failed to write BOF marker: %w", err)
-	}
+// SetFrameHeader makes the encoder write versioned frames, which carry a format version
+// and a flags byte after the BOFHeader marker. Legacy frames are written by default so
+// that older readers can still decode them.
+func (e *Encoder) SetFrameHeader(enable bool) {
+	e.header = enable
+}
 
+func (e *Encoder) Encode(value interface{}) ([]byte, error) {
 	val := reflect.ValueOf(value)
 	if val.Kind() == reflect.Interface && !val.IsNil() {
 		value = val.Elem().Interface()
@@ -307,6 +588,13 @@ func (e *Encoder) Encode(value interface{}) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("encoding failed: %w", err)
 	}
+	return e.EncodeAs(tag, value)
+}
+
+// EncodeAs encodes value as a frame with the given tag instead of the tag registered
+// for its Go type. This is how generic values from a registry built with
+// NewRegistryFromSchema are encoded.
+func (e *Encoder) EncodeAs(tag byte, value interface{}) ([]byte, error) {
 	codec, err := e.registry.GetCodec(tag)
 	if err != nil {
 		return nil, fmt.Errorf("encoding failed: %w", err)
@@ -315,16 +603,54 @@ func (e *Encoder) Encode(value interface{}) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("encoding failed for tag %d: %w", tag, err)
 	}
+	return e.encodeFrame(tag, payload)
+}
+
+// encodeFrame wraps an encoded payload in a frame, compressing, encrypting and signing
+// it as configured. Signed frames are numbered in the order they pass through here.
+func (e *Encoder) encodeFrame(tag byte, payload []byte) ([]byte, error) {
+	var err error
+	var flags FrameFlags
+	if e.compressor != nil && len(payload) >= e.compressThreshold {
+		compressed, err := compressPayload(e.compressor, payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if len(compressed) < len(payload) {
+			payload = compressed
+			flags |= FlagCompressed
+		}
+	}
+
+	// The full set of flags is authenticated by both encryption and signing.
+	if e.macKey != nil {
+		flags |= FlagChecksummed
+	}
+	if e.keyring != nil {
+		flags |= FlagEncrypted
+		if payload, err = e.keyring.seal(flags, tag, payload); err != nil {
+			return nil, fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+	}
+
+	e.buffer.Reset()
+	if e.header || flags != 0 {
+		e.buffer.Write([]byte{BOFHeader, FrameVersion, byte(flags)})
+	} else {
+		e.buffer.WriteByte(BOF)
+	}
 	if err := e.buffer.WriteByte(tag); err != nil {
 		return nil, err
 	}
-	e.buffer.WriteByte(2) // length-of-length
-	if err := binary.Write(e.buffer, binary.BigEndian, uint16(len(payload))); err != nil {
+	if err := writeLength(e.buffer, len(payload)); err != nil {
 		return nil, fmt.Errorf("failed to write payload length: %w", err)
 	}
 	if _, err := e.buffer.Write(payload); err != nil {
 		return nil, fmt.Errorf("failed to write payload: %w", err)
 	}
+	if flags&FlagChecksummed != 0 {
+		e.buffer.Write(e.sign(flags, tag, payload))
+	}
 	if err := e.buffer.WriteByte(EOF); err != nil {
 		return nil, fmt.Errorf("failed to write EOF marker: %w", err)
 	}
@@ -336,45 +662,151 @@ func (e *Encoder) Encode(value interface{}) ([]byte, error) {
 type Decoder struct {
 	registry *CodecRegistry
 	reader   io.Reader
+
+	maxFrameSize    int                 // Largest payload accepted, checked before allocating it
+	compressors     map[byte]Compressor // By ID, for frames with FlagCompressed
+	maxDecompressed int
+	keyring         *Keyring     // Opens frames with FlagEncrypted; required when set
+	macLookup       MACKeyLookup // Verifies frames with FlagChecksummed; required when set
+	session         []byte
+	replay          *replayWindow
 }
 
 func NewDecoder(registry *CodecRegistry, reader io.Reader) *Decoder {
-	return &Decoder{registry: registry, reader: reader}
+	return &Decoder{
+		registry: registry,
+		reader:   reader,
+
+		maxFrameSize:    DefaultMaxFrameSize,
+		compressors:     builtinCompressors(),
+		maxDecompressed: DefaultMaxDecompressedSize,
+	}
 }
 
+// DefaultMaxFrameSize is the largest frame payload a Decoder accepts unless told
+// otherwise.
+const DefaultMaxFrameSize = 16 << 20
+
+// SetMaxFrameSize sets the largest frame payload, as sent, that the decoder accepts.
+// Longer frames are rejected before their payload is allocated, so a peer cannot make
+// the decoder allocate up to 4 GiB by sending a large length. Zero or less restores
+// DefaultMaxFrameSize.
+func (d *Decoder) SetMaxFrameSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxFrameSize
+	}
+	d.maxFrameSize = n
+}
+
+// Decode reads the next frame. Both legacy frames (BOF) and versioned frames
+// (BOFHeader) are accepted.
 func (d *Decoder) Decode() (interface{}, error) {
-	if err := d.readMarker(BOF, "BOF"); err != nil {
-		return nil, err
+	_, value, err := d.DecodeTagged()
+	return value, err
+}
+
+// DecodeTagged is like Decode but also returns the frame's tag, so callers can
+// dispatch on it without a type switch.
+func (d *Decoder) DecodeTagged() (byte, interface{}, error) {
+	tag, payload, err := d.readFrame()
+	if err != nil {
+		return 0, nil, err
 	}
-	tag, err := d.readByte()
+	codec, err := d.registry.GetCodec(tag)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read tag: %w", err)
+		return tag, nil, fmt.Errorf("decoding failed: %w", err)
 	}
-	lol, err := d.readByte()
+	value, err := codec.Decode(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read length-of-length: %w", err)
+		return tag, nil, fmt.Errorf("decoding failed for tag %d: %w", tag, err)
 	}
-	lengthBytes := make([]byte, lol)
-	if _, err := io.ReadFull(d.reader, lengthBytes); err != nil {
-		return nil, fmt.Errorf("failed to read length bytes: %w", err)
+	return tag, value, nil
+}
+
+// readFrame reads the next frame and returns its tag and payload after verifying,
+// decrypting and decompressing it as its flags require.
+func (d *Decoder) readFrame() (byte, []byte, error) {
+	flags, err := d.readHeader()
+	if err != nil {
+		return 0, nil, err
+	}
+	tag, err := d.readByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read tag: %w", err)
+	}
+	length, err := readLength(d.reader, d.registry.opts, d.maxFrameSize)
+	if err != nil {
+		return 0, nil, fmt.Errorf("frame with tag %d: %w", tag, err)
 	}
-	length := binary.BigEndian.Uint16(lengthBytes)
 	payload := make([]byte, length)
 	if _, err := io.ReadFull(d.reader, payload); err != nil {
-		return nil, fmt.Errorf("failed to read payload: %w", err)
+		return 0, nil, fmt.Errorf("failed to read payload: %w", err)
 	}
-	codec, err := d.registry.GetCodec(tag)
-	if err != nil {
-		return nil, fmt.Errorf("decoding failed: %w", err)
+	var trailer []byte
+	if flags&FlagChecksummed != 0 {
+		trailer = make([]byte, macTrailerSize)
+		if _, err := io.ReadFull(d.reader, trailer); err != nil {
+			return 0, nil, fmt.Errorf("failed to read frame MAC: %w", err)
+		}
 	}
-	value, err := codec.Decode(payload)
+	// Read the whole frame before checking it, so a rejected frame does not leave
+	// the stream out of step.
+	if err := d.readMarker(EOF, "EOF"); err != nil {
+		return 0, nil, err
+	}
+
+	if trailer != nil && d.macLookup != nil {
+		if err := d.verify(flags, tag, payload, trailer); err != nil {
+			return 0, nil, err
+		}
+	} else if d.macLookup != nil {
+		return 0, nil, fmt.Errorf("frame with tag %d is not signed", tag)
+	}
+	if flags&FlagEncrypted != 0 {
+		if d.keyring == nil {
+			return 0, nil, fmt.Errorf("frame with tag %d is encrypted but the decoder has no keyring", tag)
+		}
+		if payload, err = d.keyring.open(flags, tag, payload); err != nil {
+			return 0, nil, err
+		}
+	} else if d.keyring != nil {
+		return 0, nil, fmt.Errorf("frame with tag %d is not encrypted", tag)
+	}
+	if flags&FlagCompressed != 0 {
+		if payload, err = d.decompress(payload); err != nil {
+			return 0, nil, err
+		}
+	}
+	return tag, payload, nil
+}
+
+// readHeader reads the frame marker and, for versioned frames, the version and flags.
+// Legacy frames have no flags.
+func (d *Decoder) readHeader() (FrameFlags, error) {
+	marker, err := d.readByte()
 	if err != nil {
-		return nil, fmt.Errorf("decoding failed for tag %d: %w", tag, err)
+		return 0, fmt.Errorf("failed to read BOF marker: %w", err)
 	}
-	if err := d.readMarker(EOF, "EOF"); err != nil {
-		return nil, err
+	switch marker {
+	case BOF:
+		return 0, nil
+	case BOFHeader:
+	default:
+		return 0, fmt.Errorf("invalid BOF marker: expected 0x%X or 0x%X, got 0x%X", BOF, BOFHeader, marker)
 	}
-	return value, nil
+
+	var header [2]byte
+	if _, err := io.ReadFull(d.reader, header[:]); err != nil {
+		return 0, fmt.Errorf("failed to read frame header: %w", err)
+	}
+	version, flags := header[0], FrameFlags(header[1])
+	if version == 0 || version > FrameVersion {
+		return 0, fmt.Errorf("unsupported frame version %d (this decoder reads up to %d)", version, FrameVersion)
+	}
+	if unknown := flags &^ supportedFrameFlags; unknown != 0 {
+		return 0, fmt.Errorf("unsupported frame flags: %v", unknown)
+	}
+	return flags, nil
 }
 
 func (d *Decoder) readMarker(expected byte, name string) error {
@@ -394,6 +826,51 @@ func (d *Decoder) readByte() (byte, error) {
 	return b[0], err
 }
 
+// writeLength writes a length-of-length byte followed by n in big-endian order.
+// Lengths that fit in 16 bits keep the original 2-byte layout; larger ones use 4 bytes.
+func writeLength(buf *bytes.Buffer, n int) error {
+	if n <= math.MaxUint16 {
+		buf.WriteByte(2)
+		return binary.Write(buf, binary.BigEndian, uint16(n))
+	}
+	if uint64(n) > math.MaxUint32 {
+		return fmt.Errorf("payload length %d exceeds the maximum of %d bytes", n, uint32(math.MaxUint32))
+	}
+	buf.WriteByte(4)
+	return binary.Write(buf, binary.BigEndian, uint32(n))
+}
+
+// readLength reads a length-of-length byte and the big-endian length that follows it.
+// Lengths above limit are rejected so that callers can allocate the length safely.
+// In strict mode the length must use the layout writeLength would have chosen.
+func readLength(r io.Reader, opts *decodeOptions, limit int) (uint32, error) {
+	var lol [1]byte
+	if _, err := io.ReadFull(r, lol[:]); err != nil {
+		return 0, fmt.Errorf("failed to read length-of-length: %w", err)
+	}
+	if lol[0] == 0 || lol[0] > 4 {
+		return 0, fmt.Errorf("invalid length-of-length %d", lol[0])
+	}
+	var lengthBytes [4]byte
+	if _, err := io.ReadFull(r, lengthBytes[4-lol[0]:]); err != nil {
+		return 0, fmt.Errorf("failed to read length bytes: %w", err)
+	}
+	length := binary.BigEndian.Uint32(lengthBytes[:])
+	if int64(length) > int64(limit) {
+		return 0, fmt.Errorf("length %d exceeds the limit of %d bytes", length, limit)
+	}
+	if opts.isStrict() {
+		minimal := byte(2)
+		if length > math.MaxUint16 {
+			minimal = 4
+		}
+		if lol[0] != minimal {
+			return 0, fmt.Errorf("non-minimal length encoding: %d length bytes for length %d", lol[0], length)
+		}
+	}
+	return length, nil
+}
+
 // --- Primitive Codec Implementations ---
 
 // Integer Codecs
@@ -707,7 +1184,9 @@ func (c *Complex128Codec) Decode(data []byte) (interface{}, error) {
 }
 
 // Other Primitive Codecs
-type BoolCodec struct{}
+type BoolCodec struct {
+	codecOptions
+}
 
 func (c *BoolCodec) Encode(value interface{}) ([]byte, error) {
 	boolVal, ok := value.(bool)
@@ -724,10 +1203,15 @@ func (c *BoolCodec) Decode(data []byte) (interface{}, error) {
 	if len(data) != 1 {
 		return nil, fmt.Errorf("invalid data length for bool: expected 1, got %d", len(data))
 	}
+	if data[0] > 1 && c.opts.isStrict() {
+		return nil, fmt.Errorf("invalid bool value %d: expected 0 or 1", data[0])
+	}
 	return data[0] == 1, nil
 }
 
-type StringCodec struct{}
+type StringCodec struct {
+	codecOptions
+}
 
 func (c *StringCodec) Encode(value interface{}) ([]byte, error) {
 	strVal, ok := value.(string)
@@ -738,6 +1222,9 @@ func (c *StringCodec) Encode(value interface{}) ([]byte, error) {
 }
 
 func (c *StringCodec) Decode(data []byte) (interface{}, error) {
+	if c.opts.isStrict() && !utf8.Valid(data) {
+		return nil, fmt.Errorf("invalid UTF-8 in string")
+	}
 	return string(data), nil
 }
 
@@ -803,8 +1290,9 @@ func (c *StructCodec) Encode(value interface{}) ([]byte, error) {
 			return nil, fmt.Errorf("error encoding field %s: %w", field.name, err)
 		}
 		buffer.WriteByte(field.typeTag)
-		buffer.WriteByte(2)
-		binary.Write(&buffer, binary.BigEndian, uint16(len(encodedValue)))
+		if err := writeLength(&buffer, len(encodedValue)); err != nil {
+			return nil, fmt.Errorf("error encoding field %s: %w", field.name, err)
+		}
 		buffer.Write(encodedValue)
 	}
 	return buffer.Bytes(), nil
@@ -821,12 +1309,8 @@ func (c *StructCodec) Decode(data []byte) (interface{}, error) {
 		if tag != field.typeTag {
 			return nil, fmt.Errorf("type mismatch for field %s: expected tag %d, got %d", field.name, field.typeTag, tag)
 		}
-		var lol byte
-		if err := binary.Read(reader, binary.BigEndian, &lol); err != nil {
-			return nil, fmt.Errorf("failed to read length-of-length for %s: %w", field.name, err)
-		}
-		var length uint16
-		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		length, err := readLength(reader, c.registry.opts, reader.Len())
+		if err != nil {
 			return nil, fmt.Errorf("failed to read length for %s: %w", field.name, err)
 		}
 		payload := make([]byte, length)
@@ -841,6 +1325,10 @@ func (c *StructCodec) Decode(data []byte) (interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error decoding field %s: %w", field.name, err)
 		}
+		if decodedValue == nil {
+			// A nil interface value leaves the field at its zero value
+			continue
+		}
 		structField := result.FieldByName(field.name)
 		if structField.IsValid() && structField.CanSet() {
 			val := reflect.ValueOf(decodedValue)
@@ -853,11 +1341,39 @@ func (c *StructCodec) Decode(data []byte) (interface{}, error) {
 			}
 		}
 	}
+	if err := c.registry.opts.checkTrailing(reader.Len(), c.structType.String()); err != nil {
+		return nil, err
+	}
 	return result.Interface(), nil
 }
 
 // --- NEW: Support for map[string]interface{} and interface{} ---
 
+// writeShortLength writes n as the big-endian uint16 that the interface{} and
+// map[string]interface{} layouts have always used for their elements. Longer
+// elements cannot be represented and are rejected rather than truncated.
+func writeShortLength(buf *bytes.Buffer, n int, what string) error {
+	if n > math.MaxUint16 {
+		return fmt.Errorf("%s of %d bytes exceeds the %d-byte limit of the dynamic value layout", what, n, math.MaxUint16)
+	}
+	return binary.Write(buf, binary.BigEndian, uint16(n))
+}
+
+// readShortLength reads a length written by writeShortLength and checks it against
+// the remaining data.
+func readShortLength(reader *bytes.Reader) (int, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return 0, fmt.Errorf("failed to read length: %w", err)
+	}
+	if int(length) > reader.Len() {
+		return 0, fmt.Errorf("length %d exceeds the remaining %d bytes", length, reader.Len())
+	}
+	return int(length), nil
+}
+
+// InterfaceCodec handles interface{} values as the tag of the concrete type, the
+// payload length as a uint16 and the payload.
 type InterfaceCodec struct {
 	registry *CodecRegistry
 }
@@ -882,25 +1398,27 @@ func (c *InterfaceCodec) Encode(value interface{}) ([]byte, error) {
 		return nil, err
 	}
 
-	buf := make([]byte, 1+2+len(data))
-	buf[0] = tag
-	binary.BigEndian.PutUint16(buf[1:3], uint16(len(data)))
-	copy(buf[3:], data)
-	return buf, nil
+	buf := bytes.NewBuffer(make([]byte, 0, 1+2+len(data)))
+	buf.WriteByte(tag)
+	if err := writeShortLength(buf, len(data), fmt.Sprintf("interface value %T", value)); err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
 }
 
 func (c *InterfaceCodec) Decode(data []byte) (interface{}, error) {
 	if len(data) == 0 {
 		return nil, nil
 	}
-	if len(data) < 3 {
-		return nil, fmt.Errorf("invalid interface data: too short")
-	}
-
+	reader := bytes.NewReader(data[1:])
 	tag := data[0]
-	length := binary.BigEndian.Uint16(data[1:3])
-	if uint16(len(data)) < 3+length {
-		return nil, fmt.Errorf("invalid interface data: length mismatch")
+	length, err := readShortLength(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface data: %w", err)
+	}
+	if err := c.registry.opts.checkTrailing(reader.Len()-length, "interface"); err != nil {
+		return nil, err
 	}
 
 	codec, err := c.registry.GetCodec(tag)
@@ -911,6 +1429,9 @@ func (c *InterfaceCodec) Decode(data []byte) (interface{}, error) {
 	return codec.Decode(data[3 : 3+length])
 }
 
+// MapStringAnyCodec handles map[string]interface{} as the entry count (uint32) followed
+// by, per entry, the key and the InterfaceCodec encoding of the value, each prefixed
+// with its length as a uint16.
 type MapStringAnyCodec struct {
 	registry *CodecRegistry
 }
@@ -927,7 +1448,7 @@ func (c *MapStringAnyCodec) Encode(value interface{}) ([]byte, error) {
 		return nil, err
 	}
 
-	stringCodec := &StringCodec{}
+	stringCodec := &StringCodec{codecOptions{c.registry.opts}}
 	anyCodec := &InterfaceCodec{registry: c.registry}
 
 	for k, v := range m {
@@ -935,7 +1456,7 @@ func (c *MapStringAnyCodec) Encode(value interface{}) ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		if err := binary.Write(buf, binary.BigEndian, uint16(len(kBytes))); err != nil {
+		if err := writeShortLength(buf, len(kBytes), "map key"); err != nil {
 			return nil, err
 		}
 		if _, err := buf.Write(kBytes); err != nil {
@@ -946,7 +1467,7 @@ func (c *MapStringAnyCodec) Encode(value interface{}) ([]byte, error) {
 		if err != nil {
 			return nil, fmt.Errorf("encoding map value for key %s: %w", k, err)
 		}
-		if err := binary.Write(buf, binary.BigEndian, uint16(len(vBytes))); err != nil {
+		if err := writeShortLength(buf, len(vBytes), fmt.Sprintf("map value for key %s", k)); err != nil {
 			return nil, err
 		}
 		if _, err := buf.Write(vBytes); err != nil {
@@ -964,15 +1485,19 @@ func (c *MapStringAnyCodec) Decode(data []byte) (interface{}, error) {
 	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
 		return nil, err
 	}
+	// Every entry has at least two 2-byte lengths
+	if int64(count) > int64(reader.Len()/4) {
+		return nil, fmt.Errorf("invalid map count %d for %d bytes of data", count, reader.Len())
+	}
 
 	result := make(map[string]interface{}, count)
-	stringCodec := &StringCodec{}
+	stringCodec := &StringCodec{codecOptions{c.registry.opts}}
 	anyCodec := &InterfaceCodec{registry: c.registry}
 
 	for i := 0; i < int(count); i++ {
-		var kLen uint16
-		if err := binary.Read(reader, binary.BigEndian, &kLen); err != nil {
-			return nil, err
+		kLen, err := readShortLength(reader)
+		if err != nil {
+			return nil, fmt.Errorf("map key %d: %w", i, err)
 		}
 		kBytes := make([]byte, kLen)
 		if _, err := io.ReadFull(reader, kBytes); err != nil {
@@ -984,9 +1509,9 @@ func (c *MapStringAnyCodec) Decode(data []byte) (interface{}, error) {
 		}
 		key := keyVal.(string)
 
-		var vLen uint16
-		if err := binary.Read(reader, binary.BigEndian, &vLen); err != nil {
-			return nil, err
+		vLen, err := readShortLength(reader)
+		if err != nil {
+			return nil, fmt.Errorf("map value for key %s: %w", key, err)
 		}
 		vBytes := make([]byte, vLen)
 		if _, err := io.ReadFull(reader, vBytes); err != nil {
@@ -997,12 +1522,119 @@ func (c *MapStringAnyCodec) Decode(data []byte) (interface{}, error) {
 			return nil, fmt.Errorf("decoding map value for key %s: %w", key, err)
 		}
 
+		if _, dup := result[key]; dup && c.registry.opts.isStrict() {
+			return nil, fmt.Errorf("duplicate map key %s", key)
+		}
 		result[key] = val
 	}
 
+	if err := c.registry.opts.checkTrailing(reader.Len(), "map[string]interface{}"); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
+// InterfaceTypeCodec handles fields typed as a non-empty interface, such as io.Reader.
+// It writes the tag of the value's concrete type followed by that type's payload.
+// Only the implementations passed to RegisterInterface are accepted.
+type InterfaceTypeCodec struct {
+	registry  *CodecRegistry
+	ifaceType reflect.Type
+	impls     map[byte]reflect.Type
+}
+
+// RegisterInterface registers the interface type I together with the concrete
+// implementations that may be stored in it. Calling it again for the same interface
+// adds further implementations. It returns the tag assigned to the interface type.
+func RegisterInterface[I any](r *CodecRegistry, impls ...I) (byte, error) {
+	ifaceType := reflect.TypeOf((*I)(nil)).Elem()
+	if ifaceType.Kind() != reflect.Interface {
+		return 0, fmt.Errorf("RegisterInterface requires an interface type, got %v", ifaceType)
+	}
+
+	tag, exists := r.types[ifaceType]
+	var codec *InterfaceTypeCodec
+	if exists {
+		existing, ok := r.codecs[tag].(*InterfaceTypeCodec)
+		if !ok {
+			return 0, fmt.Errorf("interface type %v is already registered with tag %d", ifaceType, tag)
+		}
+		codec = existing
+	} else {
+		codec = &InterfaceTypeCodec{registry: r, ifaceType: ifaceType, impls: make(map[byte]reflect.Type)}
+	}
+
+	for i, impl := range impls {
+		implVal := reflect.ValueOf(impl)
+		if !implVal.IsValid() {
+			return 0, fmt.Errorf("implementation %d of %v is nil", i, ifaceType)
+		}
+		implType, implTag, err := r.resolveType(implVal.Type())
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve implementation %v of %v: %w", implVal.Type(), ifaceType, err)
+		}
+		codec.impls[implTag] = implType
+	}
+	r.typeIDs = nil
+
+	if !exists {
+		tag = r.nextStructTag
+		r.nextStructTag++
+		r.registerType(tag, codec, ifaceType)
+	}
+	return tag, nil
+}
+
+func (c *InterfaceTypeCodec) Encode(value interface{}) ([]byte, error) {
+	if value == nil {
+		return []byte{}, nil
+	}
+
+	tag, exists := c.registry.types[reflect.TypeOf(value)]
+	if !exists {
+		return nil, fmt.Errorf("type %T is not a registered implementation of %v", value, c.ifaceType)
+	}
+	if _, ok := c.impls[tag]; !ok {
+		return nil, fmt.Errorf("type %T is not a registered implementation of %v", value, c.ifaceType)
+	}
+
+	codec, err := c.registry.GetCodec(tag)
+	if err != nil {
+		return nil, err
+	}
+	data, err := codec.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %T as %v: %w", value, c.ifaceType, err)
+	}
+
+	buf := make([]byte, 1+len(data))
+	buf[0] = tag
+	copy(buf[1:], data)
+	return buf, nil
+}
+
+func (c *InterfaceTypeCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	tag := data[0]
+	implType, ok := c.impls[tag]
+	if !ok {
+		return nil, fmt.Errorf("tag %d is not a registered implementation of %v", tag, c.ifaceType)
+	}
+
+	codec, err := c.registry.GetCodec(tag)
+	if err != nil {
+		return nil, err
+	}
+	value, err := codec.Decode(data[1:])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %v as %v: %w", implType, c.ifaceType, err)
+	}
+	return value, nil
+}
+
 // --- NEW: Collection Codecs (Slices, Arrays, Maps) ---
 
 // SliceCodec handles slice types []T.
@@ -1010,6 +1642,7 @@ func (c *MapStringAnyCodec) Decode(data []byte) (interface{}, error) {
 type SliceCodec struct {
 	elemCodec Codec
 	elemType  reflect.Type
+	codecOptions
 }
 
 func (c *SliceCodec) Encode(value interface{}) ([]byte, error) {
@@ -1053,26 +1686,28 @@ func (c *SliceCodec) Decode(data []byte) (interface{}, error) {
 	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
 		return nil, fmt.Errorf("failed to read slice count: %w", err)
 	}
+	// Every element has at least a 4-byte length
+	if int64(count) > int64(reader.Len()/4) {
+		return nil, fmt.Errorf("invalid slice count %d for %d bytes of data", count, reader.Len())
+	}
 
 	// Create a slice of the appropriate type
 	sliceType := reflect.SliceOf(c.elemType)
 	slice := reflect.MakeSlice(sliceType, int(count), int(count))
 
 	for i := 0; i < int(count); i++ {
-		var elemLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &elemLen); err != nil {
-			return nil, fmt.Errorf("failed to read element %d length: %w", i, err)
-		}
-
-		elemData := make([]byte, elemLen)
-		if _, err := io.ReadFull(reader, elemData); err != nil {
-			return nil, fmt.Errorf("failed to read element %d data: %w", i, err)
+		elemData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read element %d: %w", i, err)
 		}
 
 		elemVal, err := c.elemCodec.Decode(elemData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode element %d: %w", i, err)
 		}
+		if elemVal == nil {
+			continue
+		}
 
 		// Set the element in the slice
 		rv := reflect.ValueOf(elemVal)
@@ -1082,6 +1717,9 @@ func (c *SliceCodec) Decode(data []byte) (interface{}, error) {
 		slice.Index(i).Set(rv)
 	}
 
+	if err := c.opts.checkTrailing(reader.Len(), "slice"); err != nil {
+		return nil, err
+	}
 	return slice.Interface(), nil
 }
 
@@ -1091,6 +1729,7 @@ type ArrayCodec struct {
 	elemCodec Codec
 	elemType  reflect.Type
 	arrayLen  int
+	codecOptions
 }
 
 func (c *ArrayCodec) Encode(value interface{}) ([]byte, error) {
@@ -1133,20 +1772,18 @@ func (c *ArrayCodec) Decode(data []byte) (interface{}, error) {
 	array := reflect.New(arrayType).Elem()
 
 	for i := 0; i < c.arrayLen; i++ {
-		var elemLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &elemLen); err != nil {
-			return nil, fmt.Errorf("failed to read array element %d length: %w", i, err)
-		}
-
-		elemData := make([]byte, elemLen)
-		if _, err := io.ReadFull(reader, elemData); err != nil {
-			return nil, fmt.Errorf("failed to read array element %d data: %w", i, err)
+		elemData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read array element %d: %w", i, err)
 		}
 
 		elemVal, err := c.elemCodec.Decode(elemData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode array element %d: %w", i, err)
 		}
+		if elemVal == nil {
+			continue
+		}
 
 		// Set the element in the array
 		rv := reflect.ValueOf(elemVal)
@@ -1156,6 +1793,9 @@ func (c *ArrayCodec) Decode(data []byte) (interface{}, error) {
 		array.Index(i).Set(rv)
 	}
 
+	if err := c.opts.checkTrailing(reader.Len(), "array"); err != nil {
+		return nil, err
+	}
 	return array.Interface(), nil
 }
 
@@ -1166,6 +1806,7 @@ type MapCodec struct {
 	valCodec Codec
 	keyType  reflect.Type
 	valType  reflect.Type
+	codecOptions
 }
 
 func (c *MapCodec) Encode(value interface{}) ([]byte, error) {
@@ -1224,20 +1865,19 @@ func (c *MapCodec) Decode(data []byte) (interface{}, error) {
 	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
 		return nil, fmt.Errorf("failed to read map count: %w", err)
 	}
+	// Every entry has at least two 4-byte lengths
+	if int64(count) > int64(reader.Len()/8) {
+		return nil, fmt.Errorf("invalid map count %d for %d bytes of data", count, reader.Len())
+	}
 
 	// Create a map of the appropriate type
 	mapType := reflect.MapOf(c.keyType, c.valType)
 	m := reflect.MakeMap(mapType)
 
 	for i := 0; i < int(count); i++ {
-		var keyLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &keyLen); err != nil {
-			return nil, fmt.Errorf("failed to read map entry %d key length: %w", i, err)
-		}
-
-		keyData := make([]byte, keyLen)
-		if _, err := io.ReadFull(reader, keyData); err != nil {
-			return nil, fmt.Errorf("failed to read map entry %d key data: %w", i, err)
+		keyData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read map entry %d key: %w", i, err)
 		}
 
 		keyVal, err := c.keyCodec.Decode(keyData)
@@ -1245,14 +1885,9 @@ func (c *MapCodec) Decode(data []byte) (interface{}, error) {
 			return nil, fmt.Errorf("failed to decode map entry %d key: %w", i, err)
 		}
 
-		var valLen uint32
-		if err := binary.Read(reader, binary.BigEndian, &valLen); err != nil {
-			return nil, fmt.Errorf("failed to read map entry %d value length: %w", i, err)
-		}
-
-		valData := make([]byte, valLen)
-		if _, err := io.ReadFull(reader, valData); err != nil {
-			return nil, fmt.Errorf("failed to read map entry %d value data: %w", i, err)
+		valData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read map entry %d value: %w", i, err)
 		}
 
 		valVal, err := c.valCodec.Decode(valData)
@@ -1267,44 +1902,335 @@ func (c *MapCodec) Decode(data []byte) (interface{}, error) {
 		}
 
 		valRv := reflect.ValueOf(valVal)
-		if valRv.Type().ConvertibleTo(c.valType) {
+		if !valRv.IsValid() {
+			valRv = reflect.Zero(c.valType)
+		} else if valRv.Type().ConvertibleTo(c.valType) {
 			valRv = valRv.Convert(c.valType)
 		}
 
+		if c.opts.isStrict() && m.MapIndex(keyRv).IsValid() {
+			return nil, fmt.Errorf("duplicate map key %v", keyVal)
+		}
 		m.SetMapIndex(keyRv, valRv)
 	}
 
+	if err := c.opts.checkTrailing(reader.Len(), "map"); err != nil {
+		return nil, err
+	}
 	return m.Interface(), nil
 }
 
 // --- NEW: Specialized Codecs ---
 
-// LocationCodec handles time.Location.
-// It serializes the location name (e.g., "UTC", "America/New_York").
-// Note: This is best-effort. Custom locations created via FixedZone might not be portable.
-type LocationCodec struct{}
+// LocationCodec handles *time.Location (and time.Location values).
+// It writes a kind byte followed by the details needed to rebuild the zone:
+// UTC and Local carry nothing else, IANA zones carry their name, and fixed-offset
+// zones created with time.FixedZone carry a 4-byte offset in seconds and their name.
+// Encode fails for any other zone, such as one built with time.LoadLocationFromTZData
+// under a name the zone database does not know: it has no single offset, and sending
+// one would shift times outside the period it was taken from. Build with the cryodecoder_tzdata tag to embed the IANA database as a fallback
+// for hosts without a system zoneinfo.
+type LocationCodec struct {
+	byValue bool // Decode returns time.Location instead of *time.Location
+}
+
+// Location kinds written as the first byte of a LocationCodec payload.
+const (
+	locationUTC   byte = 0
+	locationLocal byte = 1
+	locationNamed byte = 2
+	locationFixed byte = 3
+)
+
+// loadedLocations caches the result of time.LoadLocation for a zone name (nil if it
+// failed), so encoding a named zone does not hit the filesystem on every call.
+var loadedLocations sync.Map
+
+func loadedLocation(name string) *time.Location {
+	if loc, cached := loadedLocations.Load(name); cached {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = nil
+	}
+	loadedLocations.Store(name, loc)
+	return loc
+}
+
+// zoneSamples are the instants at which sameZone compares two locations: winter and
+// summer in several years, so that fixed zones and zones with daylight saving time
+// or historical changes are told apart.
+var zoneSamples = func() []time.Time {
+	var samples []time.Time
+	for _, year := range []int{1950, 1970, 1990, 2000, 2010, 2020, 2030} {
+		samples = append(samples,
+			time.Date(year, time.January, 15, 12, 0, 0, 0, time.UTC),
+			time.Date(year, time.July, 15, 12, 0, 0, 0, time.UTC))
+	}
+	return samples
+}()
+
+// fixedOffset returns the offset of loc if it is the same at every zone sample.
+func fixedOffset(loc *time.Location) (int, bool) {
+	_, offset := zoneSamples[0].In(loc).Zone()
+	for _, t := range zoneSamples[1:] {
+		if _, o := t.In(loc).Zone(); o != offset {
+			return 0, false
+		}
+	}
+	return offset, true
+}
+
+// sameZone reports whether a and b have the same name and give the same abbreviation
+// and offset at every sample instant, so that b decodes as an equivalent of a.
+func sameZone(a, b *time.Location) bool {
+	if a.String() != b.String() {
+		return false
+	}
+	for _, t := range zoneSamples {
+		nameA, offsetA := t.In(a).Zone()
+		nameB, offsetB := t.In(b).Zone()
+		if nameA != nameB || offsetA != offsetB {
+			return false
+		}
+	}
+	return true
+}
 
 func (c *LocationCodec) Encode(value interface{}) ([]byte, error) {
-	loc, ok := value.(time.Location)
+	var loc *time.Location
+	switch v := value.(type) {
+	case *time.Location:
+		loc = v
+	case time.Location:
+		loc = &v
+	default:
+		return nil, fmt.Errorf("value %v is not time.Location", value)
+	}
+
+	// A nil *time.Location means UTC throughout the time package
+	if loc == nil || loc == time.UTC {
+		return []byte{locationUTC}, nil
+	}
+	if loc == time.Local {
+		return []byte{locationLocal}, nil
+	}
+
+	// Copies of UTC and Local, and zones loaded from the zone database, are sent by
+	// name. Fixed zones, including one that merely reuses a zone name, are sent with
+	// their offset so that they decode to the same offset.
+	name := loc.String()
+	switch {
+	case sameZone(loc, time.UTC):
+		return []byte{locationUTC}, nil
+	case sameZone(loc, time.Local):
+		return []byte{locationLocal}, nil
+	case name != "":
+		if loaded := loadedLocation(name); loaded != nil && sameZone(loc, loaded) {
+			return append([]byte{locationNamed}, name...), nil
+		}
+	}
+
+	offset, ok := fixedOffset(loc)
 	if !ok {
-		return nil, fmt.Errorf("value is not time.Location")
+		return nil, fmt.Errorf("time.Location %q is neither a fixed zone nor loadable by name", name)
 	}
-	return []byte(loc.String()), nil
+	result := make([]byte, 5+len(name))
+	result[0] = locationFixed
+	binary.BigEndian.PutUint32(result[1:5], uint32(int32(offset)))
+	copy(result[5:], name)
+	return result, nil
 }
 
 func (c *LocationCodec) Decode(data []byte) (interface{}, error) {
-	name := string(data)
-	if name == "" {
-		return time.UTC, nil // Default to UTC if empty, or handle as error
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid data length for time.Location: expected at least 1, got 0")
+	}
+
+	var loc *time.Location
+	switch data[0] {
+	case locationUTC:
+		loc = time.UTC
+	case locationLocal:
+		loc = time.Local
+	case locationNamed:
+		// time.LoadLocation requires I/O and might be slow.
+		// For standard names it's usually cached.
+		name := string(data[1:])
+		var err error
+		loc, err = time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load location %s: %w", name, err)
+		}
+	case locationFixed:
+		if len(data) < 5 {
+			return nil, fmt.Errorf("invalid data length for fixed time.Location: expected at least 5, got %d", len(data))
+		}
+		offset := int32(binary.BigEndian.Uint32(data[1:5]))
+		loc = time.FixedZone(string(data[5:]), int(offset))
+	default:
+		return nil, fmt.Errorf("invalid time.Location kind %d", data[0])
 	}
 
-	// time.LoadLocation requires I/O and might be slow.
-	// For standard names it's usually cached.
-	loc, err := time.LoadLocation(name)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load location %s: %w", name, err)
+	if c.byValue {
+		return *loc, nil
 	}
-	return *loc, nil
+	return loc, nil
+}
+
+// TimePrecision selects the resolution a TimeCodec writes.
+// Coarser precisions truncate the sub-second part and produce smaller payloads.
+type TimePrecision byte
+
+const (
+	TimeNanoseconds TimePrecision = iota
+	TimeMilliseconds
+	TimeSeconds
+)
+
+// timeHasZoneName marks a TimeCodec payload that ends with the zone name.
+const timeHasZoneName = 0x80
+
+// TimeCodec handles time.Time without going through MarshalBinary.
+// The payload is a header byte (precision and flags), the Unix seconds as a varint,
+// the sub-second part in the chosen precision as a uvarint (omitted for seconds),
+// the zone offset in seconds as a varint and, optionally, the zone name.
+// The header makes payloads self-describing, so peers may use different precisions.
+type TimeCodec struct {
+	precision TimePrecision
+	zoneName  bool
+	codecOptions
+}
+
+// NewTimeCodec creates a TimeCodec writing the given precision.
+// If zoneName is true the zone abbreviation (e.g. "CET") is written after the offset.
+func NewTimeCodec(precision TimePrecision, zoneName bool) *TimeCodec {
+	return &TimeCodec{precision: precision, zoneName: zoneName}
+}
+
+func (c *TimeCodec) Encode(value interface{}) ([]byte, error) {
+	t, ok := value.(time.Time)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not time.Time", value)
+	}
+
+	header := byte(c.precision)
+	if c.zoneName {
+		header |= timeHasZoneName
+	}
+	result := make([]byte, 1, 16)
+	result[0] = header
+
+	result = binary.AppendVarint(result, t.Unix())
+	switch c.precision {
+	case TimeNanoseconds:
+		result = binary.AppendUvarint(result, uint64(t.Nanosecond()))
+	case TimeMilliseconds:
+		result = binary.AppendUvarint(result, uint64(t.Nanosecond()/int(time.Millisecond)))
+	case TimeSeconds:
+	default:
+		return nil, fmt.Errorf("invalid time precision %d", c.precision)
+	}
+
+	name, offset := t.Zone()
+	result = binary.AppendVarint(result, int64(offset))
+	if c.zoneName {
+		result = append(result, name...)
+	}
+	return result, nil
+}
+
+func (c *TimeCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid data length for time.Time: expected at least 1, got 0")
+	}
+	header := data[0]
+	precision := TimePrecision(header &^ timeHasZoneName)
+	data = data[1:]
+
+	sec, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid time.Time seconds")
+	}
+	if err := c.opts.checkVarint(sec, n, "time.Time seconds"); err != nil {
+		return nil, err
+	}
+	data = data[n:]
+
+	var nsec int64
+	switch precision {
+	case TimeNanoseconds, TimeMilliseconds:
+		frac, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid time.Time sub-second part")
+		}
+		if err := c.opts.checkUvarint(frac, n, "time.Time sub-second part"); err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		// Range-check before converting, so a huge value cannot wrap into range.
+		perSecond := uint64(time.Second)
+		if precision == TimeMilliseconds {
+			perSecond = uint64(time.Second / time.Millisecond)
+		}
+		if frac >= perSecond {
+			return nil, fmt.Errorf("invalid time.Time sub-second part %d", frac)
+		}
+		nsec = int64(frac)
+		if precision == TimeMilliseconds {
+			nsec *= int64(time.Millisecond)
+		}
+	case TimeSeconds:
+	default:
+		return nil, fmt.Errorf("invalid time precision %d", precision)
+	}
+
+	offset, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid time.Time zone offset")
+	}
+	if err := c.opts.checkVarint(offset, n, "time.Time zone offset"); err != nil {
+		return nil, err
+	}
+	data = data[n:]
+
+	var name string
+	if header&timeHasZoneName != 0 {
+		name = string(data)
+	} else if len(data) != 0 {
+		return nil, fmt.Errorf("invalid data length for time.Time: %d unexpected trailing bytes", len(data))
+	}
+
+	loc := time.UTC
+	if offset != 0 || (name != "" && name != "UTC") {
+		loc = time.FixedZone(name, int(offset))
+	}
+	return time.Unix(sec, nsec).In(loc), nil
+}
+
+// DurationCodec handles time.Duration as a signed varint of nanoseconds.
+type DurationCodec struct {
+	codecOptions
+}
+
+func (c *DurationCodec) Encode(value interface{}) ([]byte, error) {
+	d, ok := value.(time.Duration)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not time.Duration", value)
+	}
+	return binary.AppendVarint(nil, int64(d)), nil
+}
+
+func (c *DurationCodec) Decode(data []byte) (interface{}, error) {
+	d, n := binary.Varint(data)
+	if n <= 0 || n != len(data) {
+		return nil, fmt.Errorf("invalid data for time.Duration")
+	}
+	if err := c.opts.checkVarint(d, n, "time.Duration"); err != nil {
+		return nil, err
+	}
+	return time.Duration(d), nil
 }
 
 // PointerCodec handles pointer types (*T).
@@ -1312,6 +2238,7 @@ func (c *LocationCodec) Decode(data []byte) (interface{}, error) {
 type PointerCodec struct {
 	elemCodec Codec
 	elemType  reflect.Type
+	codecOptions
 }
 
 func (c *PointerCodec) Encode(value interface{}) ([]byte, error) {
@@ -1343,6 +2270,9 @@ func (c *PointerCodec) Decode(data []byte) (interface{}, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("invalid pointer data: empty")
 	}
+	if err := c.opts.checkPresence(data[0], "pointer"); err != nil {
+		return nil, err
+	}
 
 	if data[0] == 0 {
 		// Return a nil pointer of the correct type
@@ -1368,45 +2298,42 @@ func (c *PointerCodec) Decode(data []byte) (interface{}, error) {
 
 // --- Support for private/built-in structs via BinaryMarshaler ---
 
+// MarshalerCodec handles types implementing encoding.BinaryMarshaler, on either the
+// value or the pointer receiver. The payload is the MarshalBinary output; its size is
+// bounded only by the enclosing TLV length.
 type MarshalerCodec struct {
 	typ reflect.Type
 }
 
-func (c *MarshalerCodec) Encode(value interface{}) ([]byte, error) {
-	m, ok := value.(encoding.BinaryMarshaler)
-	if !ok {
-		return nil, fmt.Errorf("type %v does not implement BinaryMarshaler", c.typ)
+// NewMarshalerCodec creates a MarshalerCodec for t.
+// It fails unless t or *t implements encoding.BinaryMarshaler and *t implements
+// encoding.BinaryUnmarshaler.
+func NewMarshalerCodec(t reflect.Type) (*MarshalerCodec, error) {
+	if !implementsEither(t, binaryMarshalerType) {
+		return nil, fmt.Errorf("type %v cannot be encoded: neither it nor its pointer implements %v", t, binaryMarshalerType)
 	}
-	data, err := m.MarshalBinary()
-	if err != nil {
+	if err := checkUnmarshaler(t, binaryUnmarshalerType); err != nil {
 		return nil, err
 	}
-	buf := make([]byte, 2+len(data))
-	binary.BigEndian.PutUint16(buf[0:2], uint16(len(data)))
-	copy(buf[2:], data)
-	return buf, nil
+	return &MarshalerCodec{typ: t}, nil
 }
 
-func (c *MarshalerCodec) Decode(data []byte) (interface{}, error) {
-	if len(data) < 2 {
-		return nil, fmt.Errorf("invalid data for MarshalerCodec: too short")
-	}
-	length := binary.BigEndian.Uint16(data[0:2])
-	if uint16(len(data)) < 2+length {
-		return nil, fmt.Errorf("invalid data for MarshalerCodec: length mismatch")
+func (c *MarshalerCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := asMarshaler[encoding.BinaryMarshaler](value)
+	if !ok {
+		return nil, fmt.Errorf("type %v does not implement BinaryMarshaler", c.typ)
 	}
+	return m.MarshalBinary()
+}
 
+func (c *MarshalerCodec) Decode(data []byte) (interface{}, error) {
 	ptr := reflect.New(c.typ)
-	instance := ptr.Interface()
-
-	u, ok := instance.(encoding.BinaryUnmarshaler)
+	u, ok := ptr.Interface().(encoding.BinaryUnmarshaler)
 	if !ok {
 		return nil, fmt.Errorf("type %v does not implement BinaryUnmarshaler", c.typ)
 	}
-
-	if err := u.UnmarshalBinary(data[2 : 2+length]); err != nil {
+	if err := u.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
-
 	return ptr.Elem().Interface(), nil
 }
@@ -0,0 +1,372 @@
+package CryoDecoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// appendBigInt appends a sign byte (0 for zero or positive, 1 for negative)
+// followed by the big-endian magnitude of x.
+func appendBigInt(buf []byte, x *big.Int) []byte {
+	sign := byte(0)
+	if x.Sign() < 0 {
+		sign = 1
+	}
+	return append(append(buf, sign), x.Bytes()...)
+}
+
+// parseBigInt is the inverse of appendBigInt. It consumes all of data.
+// In strict mode the magnitude must not have leading zero bytes and zero must
+// not carry the negative sign.
+func parseBigInt(data []byte, opts *decodeOptions) (*big.Int, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid big.Int data: missing sign byte")
+	}
+	if data[0] > 1 {
+		return nil, fmt.Errorf("invalid big.Int sign byte %d", data[0])
+	}
+	if opts.isStrict() {
+		if len(data) > 1 && data[1] == 0 {
+			return nil, fmt.Errorf("non-canonical big.Int: leading zero byte in magnitude")
+		}
+		if data[0] == 1 && len(data) == 1 {
+			return nil, fmt.Errorf("non-canonical big.Int: negative zero")
+		}
+	}
+	x := new(big.Int).SetBytes(data[1:])
+	if data[0] == 1 {
+		x.Neg(x)
+	}
+	return x, nil
+}
+
+// BigIntCodec handles *big.Int as a sign byte followed by the big-endian magnitude.
+// A nil pointer is written as an empty payload.
+type BigIntCodec struct {
+	codecOptions
+}
+
+func (c *BigIntCodec) Encode(value interface{}) ([]byte, error) {
+	x, ok := value.(*big.Int)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not *big.Int", value)
+	}
+	if x == nil {
+		return []byte{}, nil
+	}
+	return appendBigInt(nil, x), nil
+}
+
+func (c *BigIntCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return (*big.Int)(nil), nil
+	}
+	return parseBigInt(data, c.opts)
+}
+
+// big.Float forms written in the first byte of a BigFloatCodec payload.
+// The low bit of that byte carries the sign.
+const (
+	bigFloatZero   byte = 0 << 1
+	bigFloatFinite byte = 1 << 1
+	bigFloatInf    byte = 2 << 1
+)
+
+// BigFloatCodec handles *big.Float. The payload is a form byte (zero, finite or
+// infinite, plus the sign), the rounding mode, the precision as a uint32 and, for
+// finite values, the binary exponent as a varint followed by the big-endian mantissa.
+// Precision and rounding mode are restored, so decoded values round like the originals.
+// A nil pointer is written as an empty payload.
+type BigFloatCodec struct{}
+
+func (c *BigFloatCodec) Encode(value interface{}) ([]byte, error) {
+	f, ok := value.(*big.Float)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not *big.Float", value)
+	}
+	if f == nil {
+		return []byte{}, nil
+	}
+
+	form := bigFloatFinite
+	switch {
+	case f.IsInf():
+		form = bigFloatInf
+	case f.Sign() == 0:
+		form = bigFloatZero
+	}
+	if f.Signbit() {
+		form |= 1
+	}
+
+	result := make([]byte, 6, 16)
+	result[0] = form
+	result[1] = byte(f.Mode())
+	binary.BigEndian.PutUint32(result[2:6], uint32(f.Prec()))
+	if form&^1 != bigFloatFinite {
+		return result, nil
+	}
+
+	// f = mant * 2^exp with 0.5 <= |mant| < 1 and at most prec mantissa bits,
+	// so mant * 2^prec is an integer that holds the mantissa exactly.
+	mant := new(big.Float)
+	exp := f.MantExp(mant)
+	mant.SetMantExp(mant, int(f.Prec()))
+	mantInt, _ := mant.Abs(mant).Int(nil)
+
+	result = binary.AppendVarint(result, int64(exp))
+	return append(result, mantInt.Bytes()...), nil
+}
+
+func (c *BigFloatCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return (*big.Float)(nil), nil
+	}
+	if len(data) < 6 {
+		return nil, fmt.Errorf("invalid data length for big.Float: expected at least 6, got %d", len(data))
+	}
+	form := data[0]
+	mode := big.RoundingMode(data[1])
+	if mode > big.ToPositiveInf {
+		return nil, fmt.Errorf("invalid big.Float rounding mode %d", data[1])
+	}
+	prec := binary.BigEndian.Uint32(data[2:6])
+	if prec > big.MaxPrec {
+		return nil, fmt.Errorf("invalid big.Float precision %d", prec)
+	}
+	data = data[6:]
+	neg := form&1 == 1
+
+	f := new(big.Float).SetMode(mode)
+	switch form &^ 1 {
+	case bigFloatZero:
+		f.SetPrec(uint(prec))
+		if neg {
+			f.Neg(f)
+		}
+		return f, nil
+	case bigFloatInf:
+		f.SetInf(neg).SetPrec(uint(prec))
+		return f, nil
+	case bigFloatFinite:
+	default:
+		return nil, fmt.Errorf("invalid big.Float form %d", form&^1)
+	}
+
+	if prec == 0 {
+		return nil, fmt.Errorf("invalid big.Float precision 0 for a finite value")
+	}
+	exp, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid big.Float exponent")
+	}
+	mant := new(big.Int).SetBytes(data[n:])
+	if mant.BitLen() > int(prec) {
+		return nil, fmt.Errorf("invalid big.Float mantissa: %d bits exceed precision %d", mant.BitLen(), prec)
+	}
+
+	f.SetPrec(uint(prec)).SetInt(mant)
+	f.SetMantExp(f, int(exp)-int(prec))
+	if neg {
+		f.Neg(f)
+	}
+	return f, nil
+}
+
+// BigRatCodec handles *big.Rat. The payload is the numerator's sign byte, the
+// length of its magnitude as a uvarint, the magnitude itself and then the
+// big-endian denominator. A nil pointer is written as an empty payload. In strict
+// mode the fraction must be in lowest terms, as big.Rat keeps it.
+type BigRatCodec struct {
+	codecOptions
+}
+
+func (c *BigRatCodec) Encode(value interface{}) ([]byte, error) {
+	x, ok := value.(*big.Rat)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not *big.Rat", value)
+	}
+	if x == nil {
+		return []byte{}, nil
+	}
+	num := x.Num()
+	sign := byte(0)
+	if num.Sign() < 0 {
+		sign = 1
+	}
+	numBytes := num.Bytes()
+	result := binary.AppendUvarint([]byte{sign}, uint64(len(numBytes)))
+	result = append(result, numBytes...)
+	return append(result, x.Denom().Bytes()...), nil
+}
+
+func (c *BigRatCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return (*big.Rat)(nil), nil
+	}
+	numLen, n := binary.Uvarint(data[1:])
+	if n <= 0 || numLen > uint64(len(data)-1-n) {
+		return nil, fmt.Errorf("invalid big.Rat numerator length")
+	}
+	if err := c.opts.checkUvarint(numLen, n, "big.Rat numerator length"); err != nil {
+		return nil, err
+	}
+	num, err := parseBigInt(append([]byte{data[0]}, data[1+n:1+n+int(numLen)]...), c.opts)
+	if err != nil {
+		return nil, err
+	}
+	denomBytes := data[1+n+int(numLen):]
+	denom := new(big.Int).SetBytes(denomBytes)
+	if denom.Sign() == 0 {
+		return nil, fmt.Errorf("invalid big.Rat: zero denominator")
+	}
+	if c.opts.isStrict() {
+		if denomBytes[0] == 0 {
+			return nil, fmt.Errorf("non-canonical big.Rat: leading zero byte in denominator")
+		}
+		if new(big.Int).GCD(nil, nil, new(big.Int).Abs(num), denom).Cmp(big.NewInt(1)) != 0 {
+			return nil, fmt.Errorf("non-canonical big.Rat: %v/%v is not in lowest terms", num, denom)
+		}
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
+
+// Decimal is a fixed-point decimal number with the value Unscaled * 10^-Scale.
+// For example, 123.45 is {Unscaled: 12345, Scale: 2}. A nil Unscaled means zero.
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+// MaxDecimalScale is the largest |Scale| a Decimal may have to be parsed, encoded
+// or decoded. Rat and String cost time and memory proportional to |Scale|, so
+// without a bound a small frame could make them allocate gigabytes.
+const MaxDecimalScale = 1 << 16
+
+// checkDecimalScale reports an error if |scale| exceeds MaxDecimalScale.
+func checkDecimalScale(scale int64) error {
+	if scale < -MaxDecimalScale || scale > MaxDecimalScale {
+		return fmt.Errorf("Decimal scale %d exceeds the limit of %d", scale, MaxDecimalScale)
+	}
+	return nil
+}
+
+// NewDecimal returns the decimal unscaled * 10^-scale.
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	return Decimal{Unscaled: big.NewInt(unscaled), Scale: scale}
+}
+
+// ParseDecimal parses a decimal string such as "-123.450" or "1e-3".
+// The scale is taken from the number of fractional digits and the exponent.
+func ParseDecimal(s string) (Decimal, error) {
+	mantissa, exponent := s, int64(0)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mantissa = s[:i]
+		var err error
+		exponent, err = strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal exponent in %q: %w", s, err)
+		}
+	}
+
+	scale := int64(0)
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		scale = int64(len(mantissa) - i - 1)
+		mantissa = mantissa[:i] + mantissa[i+1:]
+	}
+	scale -= exponent
+	if err := checkDecimalScale(scale); err != nil {
+		return Decimal{}, fmt.Errorf("invalid decimal %q: %w", s, err)
+	}
+
+	unscaled, ok := new(big.Int).SetString(mantissa, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal{Unscaled: unscaled, Scale: int32(scale)}, nil
+}
+
+// Rat returns the exact value of d as a big.Rat. Its cost grows with |Scale|.
+func (d Decimal) Rat() *big.Rat {
+	r := new(big.Rat)
+	if d.Unscaled == nil {
+		return r
+	}
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs32(d.Scale))), nil)
+	if d.Scale >= 0 {
+		return r.SetFrac(d.Unscaled, pow)
+	}
+	return r.SetInt(new(big.Int).Mul(d.Unscaled, pow))
+}
+
+// String formats d in plain decimal notation with exactly Scale fractional digits.
+func (d Decimal) String() string {
+	unscaled := d.Unscaled
+	if unscaled == nil {
+		unscaled = new(big.Int)
+	}
+	if d.Scale <= 0 {
+		return unscaled.String() + strings.Repeat("0", int(-int64(d.Scale)))
+	}
+
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) <= int(d.Scale) {
+		digits = strings.Repeat("0", int(d.Scale)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	sign := ""
+	if unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func abs32(x int32) int64 {
+	if x < 0 {
+		return -int64(x)
+	}
+	return int64(x)
+}
+
+// DecimalCodec handles Decimal as the scale (varint) followed by the unscaled
+// value's sign byte and big-endian magnitude. Scales beyond MaxDecimalScale are
+// rejected in both directions.
+type DecimalCodec struct {
+	codecOptions
+}
+
+func (c *DecimalCodec) Encode(value interface{}) ([]byte, error) {
+	d, ok := value.(Decimal)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not Decimal", value)
+	}
+	if err := checkDecimalScale(int64(d.Scale)); err != nil {
+		return nil, err
+	}
+	unscaled := d.Unscaled
+	if unscaled == nil {
+		unscaled = new(big.Int)
+	}
+	return appendBigInt(binary.AppendVarint(nil, int64(d.Scale)), unscaled), nil
+}
+
+func (c *DecimalCodec) Decode(data []byte) (interface{}, error) {
+	scale, n := binary.Varint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid Decimal scale")
+	}
+	if err := c.opts.checkVarint(scale, n, "Decimal scale"); err != nil {
+		return nil, err
+	}
+	if err := checkDecimalScale(scale); err != nil {
+		return nil, err
+	}
+	unscaled, err := parseBigInt(data[n:], c.opts)
+	if err != nil {
+		return nil, err
+	}
+	return Decimal{Unscaled: unscaled, Scale: int32(scale)}, nil
+}
@@ -0,0 +1,206 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// roundTrip encodes value as a full frame and decodes it again.
+func roundTrip(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	registry.SetStrict(true)
+
+	data, err := NewEncoder(registry).Encode(value)
+	if err != nil {
+		t.Fatalf("encode %v: %v", value, err)
+	}
+	decoded, err := NewDecoder(registry, bytes.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatalf("decode %v: %v", value, err)
+	}
+	return decoded
+}
+
+func TestBigIntRoundTrip(t *testing.T) {
+	huge := new(big.Int).Lsh(big.NewInt(1), 1<<16) // 8 KiB magnitude
+	huge.Sub(huge, big.NewInt(1))
+	values := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(-1),
+		new(big.Int).SetUint64(math.MaxUint64),
+		new(big.Int).Neg(new(big.Int).SetUint64(math.MaxUint64)),
+		huge,
+		new(big.Int).Neg(huge),
+	}
+	for _, x := range values {
+		got := roundTrip(t, x).(*big.Int)
+		if got.Cmp(x) != 0 {
+			t.Errorf("got %v, want %v", got, x)
+		}
+	}
+	if got := roundTrip(t, (*big.Int)(nil)).(*big.Int); got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
+
+func TestBigFloatRoundTrip(t *testing.T) {
+	pi, _, err := big.ParseFloat("3."+strings.Repeat("14159265358979323846", 50), 10, 4096, big.ToNearestEven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiny := new(big.Float).SetPrec(200).SetMantExp(big.NewFloat(1), -1_000_000)
+	values := []*big.Float{
+		pi,
+		new(big.Float).Neg(pi),
+		tiny,
+		new(big.Float).SetPrec(200).SetMantExp(big.NewFloat(-1), 1_000_000),
+		new(big.Float).SetInf(false),
+		new(big.Float).SetInf(true),
+		new(big.Float).SetPrec(64),
+		new(big.Float).SetPrec(64).Neg(new(big.Float).SetPrec(64)),
+		new(big.Float).SetPrec(1 << 16).SetInt64(-42),
+	}
+	for _, x := range values {
+		got := roundTrip(t, x).(*big.Float)
+		if got.Cmp(x) != 0 || got.Signbit() != x.Signbit() || got.IsInf() != x.IsInf() {
+			t.Errorf("got %v, want %v", got, x)
+		}
+		if got.Prec() != x.Prec() || got.Mode() != x.Mode() {
+			t.Errorf("got precision %d and mode %v, want %d and %v", got.Prec(), got.Mode(), x.Prec(), x.Mode())
+		}
+	}
+}
+
+func TestBigFloatRoundingMode(t *testing.T) {
+	modes := []big.RoundingMode{
+		big.ToNearestEven, big.ToNearestAway, big.ToZero, big.AwayFromZero, big.ToNegativeInf, big.ToPositiveInf,
+	}
+	for _, mode := range modes {
+		x := new(big.Float).SetPrec(8).SetMode(mode).SetFloat64(1.5)
+		got := roundTrip(t, x).(*big.Float)
+		if got.Mode() != mode {
+			t.Errorf("got mode %v, want %v", got.Mode(), mode)
+		}
+
+		// Arithmetic on the decoded value must round the way the original does.
+		third := new(big.Float).SetPrec(100).Quo(big.NewFloat(1), big.NewFloat(3))
+		want := new(big.Float).Copy(x).Add(x, third)
+		if sum := got.Add(got, third); sum.Cmp(want) != 0 {
+			t.Errorf("mode %v: got %v, want %v", mode, sum, want)
+		}
+	}
+}
+
+func TestBigRatRoundTrip(t *testing.T) {
+	denom := new(big.Int).Exp(big.NewInt(3), big.NewInt(5000), nil)
+	values := []*big.Rat{
+		new(big.Rat),
+		big.NewRat(-1, 3),
+		new(big.Rat).SetFrac(big.NewInt(1), denom),
+		new(big.Rat).SetFrac(new(big.Int).Neg(new(big.Int).Add(denom, big.NewInt(1))), denom),
+	}
+	for _, x := range values {
+		got := roundTrip(t, x).(*big.Rat)
+		if got.Cmp(x) != 0 {
+			t.Errorf("got %v, want %v", got, x)
+		}
+	}
+}
+
+func TestDecimalRoundTrip(t *testing.T) {
+	huge := new(big.Int).Exp(big.NewInt(10), big.NewInt(500), nil)
+	values := []Decimal{
+		{},
+		NewDecimal(12345, 2),
+		NewDecimal(-1, MaxDecimalScale),
+		NewDecimal(7, -MaxDecimalScale),
+		{Unscaled: huge, Scale: 1000},
+		{Unscaled: new(big.Int).Neg(huge), Scale: -1000},
+	}
+	for _, d := range values {
+		got := roundTrip(t, d).(Decimal)
+		want := d.Unscaled
+		if want == nil {
+			want = new(big.Int)
+		}
+		if got.Scale != d.Scale || got.Unscaled.Cmp(want) != 0 {
+			t.Errorf("got %v (scale %d), want %v (scale %d)", got, got.Scale, d, d.Scale)
+		}
+	}
+}
+
+func TestParseDecimalExtremeScales(t *testing.T) {
+	for _, s := range []string{"1e-65536", "-5e65536"} {
+		d, err := ParseDecimal(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		if got := roundTrip(t, d).(Decimal); got.Scale != d.Scale || got.Unscaled.Cmp(d.Unscaled) != 0 {
+			t.Errorf("%q: got %v, want %v", s, got, d)
+		}
+	}
+	for _, s := range []string{"0.5e-65536", "1e65537", "1e-2147483647", "0.5e-2147483647"} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("%q: expected an error for a scale above MaxDecimalScale", s)
+		}
+	}
+}
+
+func TestDecimalScaleLimit(t *testing.T) {
+	codec := &DecimalCodec{}
+	for _, scale := range []int64{MaxDecimalScale + 1, -MaxDecimalScale - 1, math.MaxInt32, math.MinInt32} {
+		if _, err := codec.Encode(NewDecimal(1, int32(scale))); err == nil {
+			t.Errorf("scale %d: expected an encode error", scale)
+		}
+		if _, err := codec.Decode(append(binary.AppendVarint(nil, scale), 0, 1)); err == nil {
+			t.Errorf("scale %d: expected a decode error", scale)
+		}
+	}
+}
+
+func TestBigNumStrictDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		tag       byte
+		data      []byte
+		canonical bool
+	}{
+		{name: "big.Int", tag: 23, data: []byte{1, 5}, canonical: true},
+		{name: "big.Int leading zero", tag: 23, data: []byte{0, 0, 5}},
+		{name: "big.Int negative zero", tag: 23, data: []byte{1}},
+		{name: "big.Rat", tag: 25, data: []byte{1, 1, 2, 3}, canonical: true},
+		{name: "big.Rat zero", tag: 25, data: []byte{0, 0, 1}, canonical: true},
+		{name: "big.Rat leading zero in numerator", tag: 25, data: []byte{0, 2, 0, 1, 3}},
+		{name: "big.Rat negative zero", tag: 25, data: []byte{1, 0, 1}},
+		{name: "big.Rat zero-padded denominator", tag: 25, data: []byte{0, 1, 1, 0, 3}},
+		{name: "big.Rat not in lowest terms", tag: 25, data: []byte{0, 1, 2, 4}},
+		{name: "big.Rat zero over two", tag: 25, data: []byte{0, 0, 2}},
+		{name: "Decimal", tag: 26, data: []byte{4, 1, 5}, canonical: true},
+		{name: "Decimal leading zero", tag: 26, data: []byte{4, 0, 0, 5}},
+		{name: "Decimal negative zero", tag: 26, data: []byte{4, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				registry := NewCodecRegistry()
+				registry.RegisterPrimitives()
+				registry.SetStrict(strict)
+				codec, err := registry.GetCodec(tt.tag)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = codec.Decode(tt.data)
+				if wantErr := strict && !tt.canonical; (err != nil) != wantErr {
+					t.Errorf("strict=%v: got error %v, want error %v", strict, err, wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package CryoDecoder
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Message kinds of an rpcMessage.
+const (
+	rpcRequest byte = iota + 1
+	rpcResponse
+	rpcError
+	rpcCancel
+)
+
+// rpcMessage is the frame exchanged by RPCClient and RPCServer (tag 45). ID
+// correlates responses, errors and cancellations with their request, so one
+// connection can carry many requests at once. The body is kept encoded so that a
+// body the receiver cannot decode can still be answered with an error. BodyType is
+// the sender's typeID for BodyTag, so that a receiver whose registry assigned the
+// tag to a different type rejects the body instead of misreading it.
+type rpcMessage struct {
+	Kind     byte
+	ID       uint64
+	Timeout  time.Duration // Requests only; 0 means no deadline
+	BodyTag  byte          // Requests and responses
+	BodyType uint64        // Requests and responses
+	Body     []byte
+	Err      *RemoteError // Errors only
+}
+
+// rpcMessageCodec handles rpcMessage as the kind byte and the ID (uvarint) followed by,
+// for requests, the timeout (uvarint nanoseconds) and the body tag, type (8 bytes) and
+// payload; for responses, the body tag, type and payload; and for errors, the code and
+// message (uvarint length plus bytes each).
+type rpcMessageCodec struct{}
+
+func (c *rpcMessageCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := value.(rpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not rpcMessage", value)
+	}
+	buf := binary.AppendUvarint([]byte{m.Kind}, m.ID)
+	switch m.Kind {
+	case rpcRequest:
+		buf = binary.AppendUvarint(buf, uint64(max(m.Timeout, 0)))
+		fallthrough
+	case rpcResponse:
+		buf = binary.BigEndian.AppendUint64(append(buf, m.BodyTag), m.BodyType)
+		buf = append(buf, m.Body...)
+	case rpcError:
+		buf = appendSchemaString(buf, m.Err.Code)
+		buf = appendSchemaString(buf, m.Err.Message)
+	case rpcCancel:
+	default:
+		return nil, fmt.Errorf("invalid rpc message kind %d", m.Kind)
+	}
+	return buf, nil
+}
+
+func (c *rpcMessageCodec) Decode(data []byte) (interface{}, error) {
+	sr := &schemaReader{data: data}
+	m := rpcMessage{Kind: sr.byte()}
+	id, n := binary.Uvarint(sr.data)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid rpc message ID")
+	}
+	m.ID, sr.data = id, sr.data[n:]
+
+	switch m.Kind {
+	case rpcRequest:
+		timeout, n := binary.Uvarint(sr.data)
+		if n <= 0 || timeout > 1<<63-1 {
+			return nil, fmt.Errorf("invalid rpc request timeout")
+		}
+		m.Timeout, sr.data = time.Duration(timeout), sr.data[n:]
+		fallthrough
+	case rpcResponse:
+		m.BodyTag = sr.byte()
+		if len(sr.data) < 8 {
+			return nil, fmt.Errorf("invalid rpc message body type")
+		}
+		m.BodyType = binary.BigEndian.Uint64(sr.data)
+		m.Body, sr.data = sr.data[8:], nil
+	case rpcError:
+		m.Err = &RemoteError{Code: sr.string(), Message: sr.string()}
+	case rpcCancel:
+	default:
+		return nil, fmt.Errorf("invalid rpc message kind %d", m.Kind)
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if len(sr.data) != 0 {
+		return nil, fmt.Errorf("invalid rpc message: %d trailing bytes", len(sr.data))
+	}
+	return m, nil
+}
+
+// Error codes sent in a RemoteError by RPCServer. Handlers can return a
+// *RemoteError to choose their own code.
+const (
+	CodeError            = "error"             // the handler returned an error
+	CodeInternal         = "internal"          // the handler panicked
+	CodeUnknownRequest   = "unknown_request"   // no handler for the request type
+	CodeBadRequest       = "bad_request"       // the request could not be decoded
+	CodeTypeMismatch     = "type_mismatch"     // the server's type for the request tag differs
+	CodeCanceled         = "canceled"          // the caller canceled the request
+	CodeDeadlineExceeded = "deadline_exceeded" // the request's deadline passed
+)
+
+// RemoteError is an error returned by the remote side of an RPC call.
+type RemoteError struct {
+	Code    string
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error (%s): %s", e.Code, e.Message)
+}
+
+// ErrClientClosed is returned for calls made on, or still pending when, an
+// RPCClient is closed or loses its connection.
+var ErrClientClosed = errors.New("rpc client closed")
+
+// ErrTypeMismatch is returned by RPCClient for a response whose tag means a different
+// type on the client than on the server.
+var ErrTypeMismatch = errors.New("rpc type mismatch")
+
+// rpcHandler calls a handler registered with HandleRequest.
+type rpcHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// RPCServer dispatches requests to handlers registered by request type. Every
+// request runs in its own goroutine with a context that carries the caller's
+// deadline and is canceled when the caller cancels.
+type RPCServer struct {
+	registry *CodecRegistry
+	mu       sync.Mutex // Guards the registry, which may resolve types while encoding
+	handlers map[byte]rpcHandler
+}
+
+// NewRPCServer returns a server that encodes and decodes with registry.
+func NewRPCServer(registry *CodecRegistry) *RPCServer {
+	return &RPCServer{registry: registry, handlers: make(map[byte]rpcHandler)}
+}
+
+// HandleRequest registers fn for requests of type Req, and registers Req and Resp
+// with the server's registry. Clients must call RegisterCall with the same types in
+// the same order; requests whose tag means a different type on the server are
+// rejected with CodeTypeMismatch.
+// An error returned by fn reaches the caller as a *RemoteError; return a *RemoteError
+// to set its code.
+func HandleRequest[Req, Resp any](s *RPCServer, fn func(ctx context.Context, req Req) (Resp, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, tag, err := s.registry.resolveType(reflect.TypeOf((*Req)(nil)).Elem())
+	if err != nil {
+		return fmt.Errorf("cannot register request type: %w", err)
+	}
+	if _, _, err := s.registry.resolveType(reflect.TypeOf((*Resp)(nil)).Elem()); err != nil {
+		return fmt.Errorf("cannot register response type: %w", err)
+	}
+	s.handlers[tag] = func(ctx context.Context, req interface{}) (interface{}, error) {
+		r, ok := req.(Req)
+		if !ok {
+			return nil, &RemoteError{Code: CodeBadRequest, Message: fmt.Sprintf("expected %T, got %T", r, req)}
+		}
+		return fn(ctx, r)
+	}
+	return nil
+}
+
+// ServeConn serves requests from conn until it is closed or a frame cannot be read.
+// Handlers still running when it returns see their context canceled. A closed
+// connection returns nil.
+func (s *RPCServer) ServeConn(conn io.ReadWriteCloser) error {
+	defer conn.Close()
+	decoder := NewDecoder(s.registry, bufio.NewReader(conn))
+	var writeMu sync.Mutex
+	var wg sync.WaitGroup
+	var inflightMu sync.Mutex
+	inflight := make(map[uint64]context.CancelFunc)
+
+	ctx, cancelAll := context.WithCancel(context.Background())
+	defer func() {
+		cancelAll()
+		wg.Wait()
+	}()
+
+	send := func(m rpcMessage) {
+		s.mu.Lock()
+		frame, err := NewEncoder(s.registry).Encode(m)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.Write(frame)
+	}
+
+	for {
+		m, err := readMessage(decoder, &s.mu)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				return nil
+			}
+			return err
+		}
+
+		switch m.Kind {
+		case rpcCancel:
+			inflightMu.Lock()
+			if cancel, ok := inflight[m.ID]; ok {
+				cancel()
+			}
+			inflightMu.Unlock()
+		case rpcRequest:
+			var reqCtx context.Context
+			var cancel context.CancelFunc
+			if m.Timeout > 0 {
+				reqCtx, cancel = context.WithTimeout(ctx, m.Timeout)
+			} else {
+				reqCtx, cancel = context.WithCancel(ctx)
+			}
+			inflightMu.Lock()
+			inflight[m.ID] = cancel
+			inflightMu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reply := s.handle(reqCtx, m)
+				inflightMu.Lock()
+				delete(inflight, m.ID)
+				inflightMu.Unlock()
+				cancel()
+				send(reply)
+			}()
+		}
+	}
+}
+
+// handle decodes a request, runs its handler and returns the reply to send.
+func (s *RPCServer) handle(ctx context.Context, m rpcMessage) (reply rpcMessage) {
+	reply = rpcMessage{Kind: rpcError, ID: m.ID}
+	defer func() {
+		if p := recover(); p != nil {
+			reply = rpcMessage{Kind: rpcError, ID: m.ID, Err: &RemoteError{Code: CodeInternal, Message: fmt.Sprint(p)}}
+		}
+	}()
+
+	s.mu.Lock()
+	handler, ok := s.handlers[m.BodyTag]
+	var req interface{}
+	var err error
+	mismatch := ok && m.BodyType != s.registry.typeID(m.BodyTag)
+	if ok && !mismatch {
+		req, err = decodeTagged(s.registry, m.BodyTag, m.Body)
+	}
+	s.mu.Unlock()
+	if !ok {
+		reply.Err = &RemoteError{Code: CodeUnknownRequest, Message: fmt.Sprintf("no handler for tag %d", m.BodyTag)}
+		return reply
+	}
+	if mismatch {
+		reply.Err = &RemoteError{Code: CodeTypeMismatch, Message: fmt.Sprintf("tag %d is a different type on the server", m.BodyTag)}
+		return reply
+	}
+	if err != nil {
+		reply.Err = &RemoteError{Code: CodeBadRequest, Message: err.Error()}
+		return reply
+	}
+
+	resp, err := handler(ctx, req)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		var remote *RemoteError
+		switch {
+		case errors.As(err, &remote):
+			reply.Err = remote
+		case errors.Is(err, context.DeadlineExceeded):
+			reply.Err = &RemoteError{Code: CodeDeadlineExceeded, Message: err.Error()}
+		case errors.Is(err, context.Canceled):
+			reply.Err = &RemoteError{Code: CodeCanceled, Message: err.Error()}
+		default:
+			reply.Err = &RemoteError{Code: CodeError, Message: err.Error()}
+		}
+		return reply
+	}
+
+	s.mu.Lock()
+	tag, body, err := encodeTagged(s.registry, resp)
+	var typeID uint64
+	if err == nil {
+		typeID = s.registry.typeID(tag)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		reply.Err = &RemoteError{Code: CodeInternal, Message: fmt.Sprintf("cannot encode response: %v", err)}
+		return reply
+	}
+	return rpcMessage{Kind: rpcResponse, ID: m.ID, BodyTag: tag, BodyType: typeID, Body: body}
+}
+
+// encodeTagged encodes value with the codec registered for its type.
+func encodeTagged(r *CodecRegistry, value interface{}) (byte, []byte, error) {
+	tag, err := r.GetTag(value)
+	if err != nil {
+		return 0, nil, err
+	}
+	codec, err := r.GetCodec(tag)
+	if err != nil {
+		return 0, nil, err
+	}
+	body, err := codec.Encode(value)
+	return tag, body, err
+}
+
+// decodeTagged decodes data with the codec registered for tag.
+func decodeTagged(r *CodecRegistry, tag byte, data []byte) (interface{}, error) {
+	codec, err := r.GetCodec(tag)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(data)
+}
+
+// readMessage reads the next frame, which must be an rpcMessage. The registry is only
+// used while holding mu; reading from the connection is not.
+func readMessage(decoder *Decoder, mu *sync.Mutex) (rpcMessage, error) {
+	tag, payload, err := decoder.readFrame()
+	if err != nil {
+		return rpcMessage{}, err
+	}
+	mu.Lock()
+	value, err := decodeTagged(decoder.registry, tag, payload)
+	mu.Unlock()
+	if err != nil {
+		return rpcMessage{}, fmt.Errorf("decoding failed for tag %d: %w", tag, err)
+	}
+	m, ok := value.(rpcMessage)
+	if !ok {
+		return rpcMessage{}, fmt.Errorf("expected an rpc message, got %T", value)
+	}
+	return m, nil
+}
+
+// Future is the pending result of a request sent with RPCClient.Go.
+type Future struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Done is closed once the result is available.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the response and returns it, or the error the call failed with:
+// a *RemoteError from the server, the context's error, or ErrClientClosed.
+func (f *Future) Result() (interface{}, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// RPCClient sends requests to an RPCServer over a single connection. Any number of
+// requests may be in flight at once; it is safe for concurrent use.
+type RPCClient struct {
+	registry *CodecRegistry
+	conn     io.ReadWriteCloser
+	mu       sync.Mutex // Guards the registry, pending, nextID and err
+	writeMu  sync.Mutex
+	pending  map[uint64]*Future
+	nextID   uint64
+	err      error // Set once the client is closed or the connection fails
+}
+
+// NewRPCClient returns a client for the server on the other end of conn and starts
+// reading responses. Register the request and response types with RegisterCall.
+func NewRPCClient(registry *CodecRegistry, conn io.ReadWriteCloser) *RPCClient {
+	c := &RPCClient{registry: registry, conn: conn, pending: make(map[uint64]*Future)}
+	go c.readLoop()
+	return c
+}
+
+// RegisterCall registers Req and Resp with the client's registry the way HandleRequest
+// does on the server. Calling it for the same types in the same order as the server
+// gives both sides the same tags; a type the client only resolves on first use may
+// get a different tag, which then fails with ErrTypeMismatch or CodeTypeMismatch.
+func RegisterCall[Req, Resp any](c *RPCClient) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, _, err := c.registry.resolveType(reflect.TypeOf((*Req)(nil)).Elem()); err != nil {
+		return fmt.Errorf("cannot register request type: %w", err)
+	}
+	if _, _, err := c.registry.resolveType(reflect.TypeOf((*Resp)(nil)).Elem()); err != nil {
+		return fmt.Errorf("cannot register response type: %w", err)
+	}
+	return nil
+}
+
+// Call sends req and waits for the response. The context's deadline is sent to the
+// server; canceling the context cancels the request on the server too.
+func (c *RPCClient) Call(ctx context.Context, req interface{}) (interface{}, error) {
+	return c.Go(ctx, req).Result()
+}
+
+// Go sends req and returns a Future for its response.
+func (c *RPCClient) Go(ctx context.Context, req interface{}) *Future {
+	f := &Future{done: make(chan struct{})}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		f.err = c.err
+		close(f.done)
+		return f
+	}
+	c.nextID++
+	id := c.nextID
+	tag, body, err := encodeTagged(c.registry, req)
+	var typeID uint64
+	if err == nil {
+		typeID = c.registry.typeID(tag)
+		c.pending[id] = f
+	}
+	c.mu.Unlock()
+	if err != nil {
+		f.err = fmt.Errorf("cannot encode request: %w", err)
+		close(f.done)
+		return f
+	}
+
+	m := rpcMessage{Kind: rpcRequest, ID: id, BodyTag: tag, BodyType: typeID, Body: body}
+	if deadline, ok := ctx.Deadline(); ok {
+		m.Timeout = max(time.Until(deadline), 1)
+	}
+	if err := c.send(m); err != nil {
+		c.complete(id, nil, err)
+		return f
+	}
+
+	go func() {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			if c.complete(id, nil, ctx.Err()) {
+				c.send(rpcMessage{Kind: rpcCancel, ID: id})
+			}
+		}
+	}()
+	return f
+}
+
+// Close closes the connection. Pending calls fail with ErrClientClosed.
+func (c *RPCClient) Close() error {
+	c.fail(ErrClientClosed)
+	return c.conn.Close()
+}
+
+func (c *RPCClient) send(m rpcMessage) error {
+	c.mu.Lock()
+	frame, err := NewEncoder(c.registry).Encode(m)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.conn.Write(frame)
+	return err
+}
+
+// complete finishes the pending call id and reports whether it was still pending.
+func (c *RPCClient) complete(id uint64, value interface{}, err error) bool {
+	c.mu.Lock()
+	f, ok := c.pending[id]
+	delete(c.pending, id)
+	c.mu.Unlock()
+	if ok {
+		f.value, f.err = value, err
+		close(f.done)
+	}
+	return ok
+}
+
+// fail fails every pending call and any later ones with err.
+func (c *RPCClient) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	pending := c.pending
+	c.pending = make(map[uint64]*Future)
+	c.mu.Unlock()
+	for _, f := range pending {
+		f.err = err
+		close(f.done)
+	}
+}
+
+func (c *RPCClient) readLoop() {
+	decoder := NewDecoder(c.registry, bufio.NewReader(c.conn))
+	for {
+		m, err := readMessage(decoder, &c.mu)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				err = ErrClientClosed
+			}
+			c.fail(err)
+			return
+		}
+
+		switch m.Kind {
+		case rpcResponse:
+			var resp interface{}
+			c.mu.Lock()
+			if _, known := c.registry.codecs[m.BodyTag]; known && m.BodyType != c.registry.typeID(m.BodyTag) {
+				err = fmt.Errorf("%w: response tag %d is a different type on the client", ErrTypeMismatch, m.BodyTag)
+			} else if resp, err = decodeTagged(c.registry, m.BodyTag, m.Body); err != nil {
+				err = fmt.Errorf("cannot decode response: %w", err)
+			}
+			c.mu.Unlock()
+			c.complete(m.ID, resp, err)
+		case rpcError:
+			c.complete(m.ID, nil, m.Err)
+		}
+	}
+}
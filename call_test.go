@@ -0,0 +1,229 @@
+package CryoDecoder
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type addRequest struct {
+	A, B int64
+}
+
+type addResponse struct {
+	Sum int64
+}
+
+type echoRequest struct {
+	Text  string
+	Delay time.Duration // how long the handler waits before answering
+}
+
+type echoResponse struct {
+	Text string
+}
+
+type failRequest struct {
+	Mode string
+}
+
+// serverContexts receives why the context of a failRequest handler in "wait" mode
+// ended. DeadlineExceeded can only come from the deadline the client sent.
+type serverContexts chan error
+
+// rpcTestServer serves the test handlers on one end of a pipe and returns the other.
+func rpcTestServer(t *testing.T, seen serverContexts) net.Conn {
+	t.Helper()
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	server := NewRPCServer(registry)
+	err := errors.Join(
+		HandleRequest(server, func(ctx context.Context, req addRequest) (addResponse, error) {
+			return addResponse{Sum: req.A + req.B}, nil
+		}),
+		HandleRequest(server, func(ctx context.Context, req echoRequest) (echoResponse, error) {
+			select {
+			case <-time.After(req.Delay):
+				return echoResponse{Text: req.Text}, nil
+			case <-ctx.Done():
+				return echoResponse{}, ctx.Err()
+			}
+		}),
+		HandleRequest(server, func(ctx context.Context, req failRequest) (addResponse, error) {
+			switch req.Mode {
+			case "error":
+				return addResponse{}, errors.New("boom")
+			case "custom":
+				return addResponse{}, &RemoteError{Code: "quota", Message: "slow down"}
+			case "panic":
+				panic("handler panicked")
+			case "wait":
+				<-ctx.Done()
+				seen <- ctx.Err()
+				return addResponse{}, ctx.Err()
+			}
+			return addResponse{}, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	go server.ServeConn(a)
+	return b
+}
+
+// rpcTestClient returns a client with the request types registered in the
+// server's order.
+func rpcTestClient(t *testing.T, conn net.Conn, register func(c *RPCClient) error) *RPCClient {
+	t.Helper()
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	client := NewRPCClient(registry, conn)
+	t.Cleanup(func() { client.Close() })
+	if register == nil {
+		register = func(c *RPCClient) error {
+			return errors.Join(
+				RegisterCall[addRequest, addResponse](c),
+				RegisterCall[echoRequest, echoResponse](c),
+				RegisterCall[failRequest, addResponse](c),
+			)
+		}
+	}
+	if err := register(client); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRPCCalls(t *testing.T) {
+	tests := []struct {
+		name       string
+		register   func(c *RPCClient) error
+		req        interface{}
+		timeout    time.Duration // context timeout; zero for none
+		cancel     time.Duration // cancel the context after this long; zero for never
+		want       interface{}
+		wantCode   string // RemoteError code; either it or wantErr if both are set
+		wantErr    error
+		wantServer error // what the handler's context reported, if it waited
+	}{
+		{name: "response", req: addRequest{A: 1, B: 2}, want: addResponse{Sum: 3}},
+		{name: "handler error", req: failRequest{Mode: "error"}, wantCode: CodeError},
+		{name: "handler error code", req: failRequest{Mode: "custom"}, wantCode: "quota"},
+		{name: "handler panic", req: failRequest{Mode: "panic"}, wantCode: CodeInternal},
+		{
+			name: "unknown request",
+			register: func(c *RPCClient) error {
+				return errors.Join(
+					RegisterCall[addRequest, addResponse](c),
+					RegisterCall[echoRequest, echoResponse](c),
+					RegisterCall[failRequest, addResponse](c),
+					RegisterCall[addResponse, addResponse](c),
+				)
+			},
+			req:      addResponse{Sum: 1},
+			wantCode: CodeUnknownRequest,
+		},
+		{
+			name:     "request type mismatch",
+			register: func(c *RPCClient) error { return RegisterCall[echoRequest, echoResponse](c) },
+			req:      echoRequest{Text: "hi"},
+			wantCode: CodeTypeMismatch,
+		},
+		{
+			name:     "response type mismatch",
+			register: func(c *RPCClient) error { return RegisterCall[addRequest, echoResponse](c) },
+			req:      addRequest{A: 1, B: 2},
+			wantErr:  ErrTypeMismatch,
+		},
+		{
+			// The server's copy of the deadline may expire first, so its reply can win.
+			name:       "deadline reaches the server",
+			req:        failRequest{Mode: "wait"},
+			timeout:    50 * time.Millisecond,
+			wantErr:    context.DeadlineExceeded,
+			wantCode:   CodeDeadlineExceeded,
+			wantServer: context.DeadlineExceeded,
+		},
+		{name: "cancel reaches the server", req: failRequest{Mode: "wait"}, cancel: 50 * time.Millisecond, wantErr: context.Canceled, wantServer: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(serverContexts, 1)
+			client := rpcTestClient(t, rpcTestServer(t, seen), tt.register)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			got, err := client.Call(ctx, tt.req)
+			var remote *RemoteError
+			isCode := tt.wantCode != "" && errors.As(err, &remote) && remote.Code == tt.wantCode
+			switch {
+			case tt.wantCode != "" && tt.wantErr != nil:
+				if !isCode && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v or a RemoteError with code %s", err, tt.wantErr, tt.wantCode)
+				}
+			case tt.wantCode != "":
+				if !isCode {
+					t.Fatalf("got %v, want a RemoteError with code %s", err, tt.wantCode)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got != tt.want:
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+
+			if tt.wantServer != nil {
+				select {
+				case err := <-seen:
+					if !errors.Is(err, tt.wantServer) {
+						t.Errorf("handler context ended with %v, want %v", err, tt.wantServer)
+					}
+				case <-time.After(5 * time.Second):
+					t.Error("handler context was never canceled")
+				}
+			}
+		})
+	}
+}
+
+func TestRPCCorrelatesConcurrentCalls(t *testing.T) {
+	client := rpcTestClient(t, rpcTestServer(t, nil), nil)
+
+	// Later requests are answered first, so responses arrive out of order.
+	const calls = 20
+	futures := make([]*Future, calls)
+	for i := range futures {
+		futures[i] = client.Go(context.Background(), echoRequest{
+			Text:  string(rune('a' + i)),
+			Delay: time.Duration(calls-i) * 5 * time.Millisecond,
+		})
+	}
+	var wg sync.WaitGroup
+	for i, f := range futures {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := f.Result()
+			if want := (echoResponse{Text: string(rune('a' + i))}); err != nil || got != want {
+				t.Errorf("call %d: got %+v, %v, want %+v", i, got, err, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
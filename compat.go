@@ -0,0 +1,241 @@
+package CryoDecoder
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Fingerprint is a stable hash of the wire-relevant parts of a Schema.
+type Fingerprint [sha256.Size]byte
+
+// String returns the fingerprint in hex.
+func (f Fingerprint) String() string {
+	return hex.EncodeToString(f[:])
+}
+
+// Fingerprint hashes everything in s that affects the bytes on the wire: tags, kinds,
+// field order and field tags, element/key/value tags, array lengths, interface
+// implementations, and the names of primitives (which identify their codec). Struct
+// and field names are not sent on the wire and are left out, so renaming a Go type
+// or field does not change the fingerprint. The result does not depend on the order
+// of s.Types.
+func (s Schema) Fingerprint() Fingerprint {
+	types := sortedTypes(s)
+	buf := binary.AppendUvarint(nil, uint64(len(types)))
+	for _, ts := range types {
+		buf = append(buf, ts.Tag)
+		buf = appendSchemaString(buf, string(ts.Kind))
+		if ts.Kind == KindPrimitive {
+			buf = appendSchemaString(buf, ts.Name)
+		} else {
+			buf = appendSchemaString(buf, "")
+		}
+		buf = append(buf, ts.Elem, ts.Key, ts.Value)
+		buf = binary.AppendUvarint(buf, uint64(ts.Len))
+		buf = binary.AppendUvarint(buf, uint64(len(ts.Fields)))
+		for _, field := range ts.Fields {
+			buf = append(buf, field.Tag)
+		}
+		impls := append([]byte(nil), ts.Impls...)
+		sort.Slice(impls, func(i, j int) bool { return impls[i] < impls[j] })
+		buf = binary.AppendUvarint(buf, uint64(len(impls)))
+		buf = append(buf, impls...)
+	}
+	return sha256.Sum256(buf)
+}
+
+// Fingerprint returns the fingerprint of the registry's schema.
+func (r *CodecRegistry) Fingerprint() Fingerprint {
+	return r.Schema().Fingerprint()
+}
+
+// typeID identifies what tag means on the wire in r: the leading bytes of the
+// fingerprint of tag and every tag it refers to. Two registries that assigned tag
+// to differently shaped types get different IDs.
+func (r *CodecRegistry) typeID(tag byte) uint64 {
+	if id, ok := r.typeIDs[tag]; ok {
+		return id
+	}
+	fp := r.Schema().reachable(tag).Fingerprint()
+	id := binary.BigEndian.Uint64(fp[:8])
+	if r.typeIDs == nil {
+		r.typeIDs = make(map[byte]uint64)
+	}
+	r.typeIDs[tag] = id
+	return id
+}
+
+// SchemaChange is a single difference found by CheckCompatibility.
+type SchemaChange struct {
+	Tag         byte
+	Name        string
+	Breaking    bool
+	Description string
+}
+
+func (c SchemaChange) String() string {
+	return fmt.Sprintf("tag %d (%s): %s", c.Tag, c.Name, c.Description)
+}
+
+// Compatibility is the result of CheckCompatibility.
+type Compatibility struct {
+	Breaking    []SchemaChange
+	NonBreaking []SchemaChange
+}
+
+// Compatible reports whether no breaking changes were found.
+func (c Compatibility) Compatible() bool {
+	return len(c.Breaking) == 0
+}
+
+// Err returns an error listing the breaking changes, or nil if there are none.
+func (c Compatibility) Err() error {
+	if c.Compatible() {
+		return nil
+	}
+	msg := fmt.Sprintf("%d breaking schema change(s)", len(c.Breaking))
+	for _, change := range c.Breaking {
+		msg += "\n  " + change.String()
+	}
+	return errors.New(msg)
+}
+
+func (c *Compatibility) add(ts TypeSchema, breaking bool, format string, args ...interface{}) {
+	change := SchemaChange{Tag: ts.Tag, Name: ts.Name, Breaking: breaking, Description: fmt.Sprintf(format, args...)}
+	if breaking {
+		c.Breaking = append(c.Breaking, change)
+	} else {
+		c.NonBreaking = append(c.NonBreaking, change)
+	}
+}
+
+// CheckCompatibility lists the changes between two versions of a schema and whether
+// each breaks peers still using oldSchema. Struct fields are decoded by position, so
+// adding, removing, reordering or retyping a field is breaking, while renaming one is
+// not. Removing a tag or reusing it for a different kind or primitive is breaking;
+// adding a tag, adding an interface implementation or renaming a type is not.
+func CheckCompatibility(oldSchema, newSchema Schema) Compatibility {
+	var result Compatibility
+
+	newTypes := make(map[byte]TypeSchema, len(newSchema.Types))
+	for _, ts := range newSchema.Types {
+		newTypes[ts.Tag] = ts
+	}
+	oldTags := make(map[byte]bool, len(oldSchema.Types))
+
+	for _, o := range sortedTypes(oldSchema) {
+		oldTags[o.Tag] = true
+		n, ok := newTypes[o.Tag]
+		if !ok {
+			result.add(o, true, "removed")
+			continue
+		}
+		if o.Kind != n.Kind {
+			result.add(o, true, "reused: kind changed from %s to %s (%s)", o.Kind, n.Kind, n.Name)
+			continue
+		}
+		if o.Name != n.Name {
+			// A primitive's name identifies its codec; any other name is only a label.
+			if o.Kind == KindPrimitive {
+				result.add(o, true, "reused: primitive changed to %s", n.Name)
+				continue
+			}
+			result.add(o, false, "renamed to %s", n.Name)
+		}
+		if o.Elem != n.Elem {
+			result.add(o, true, "element tag changed from %d to %d", o.Elem, n.Elem)
+		}
+		if o.Key != n.Key {
+			result.add(o, true, "key tag changed from %d to %d", o.Key, n.Key)
+		}
+		if o.Value != n.Value {
+			result.add(o, true, "value tag changed from %d to %d", o.Value, n.Value)
+		}
+		if o.Len != n.Len {
+			result.add(o, true, "array length changed from %d to %d", o.Len, n.Len)
+		}
+		compareFields(&result, o, n)
+		compareImpls(&result, o, n)
+	}
+
+	for _, n := range sortedTypes(newSchema) {
+		if !oldTags[n.Tag] {
+			result.add(n, false, "added")
+		}
+	}
+	return result
+}
+
+func sortedTypes(s Schema) []TypeSchema {
+	types := append([]TypeSchema(nil), s.Types...)
+	sort.Slice(types, func(i, j int) bool { return types[i].Tag < types[j].Tag })
+	return types
+}
+
+// compareFields reports struct field changes between o and n.
+func compareFields(result *Compatibility, o, n TypeSchema) {
+	sameTags := len(o.Fields) == len(n.Fields)
+	for i := 0; sameTags && i < len(o.Fields); i++ {
+		sameTags = o.Fields[i].Tag == n.Fields[i].Tag
+	}
+	if sameTags {
+		for i := range o.Fields {
+			if o.Fields[i].Name != n.Fields[i].Name {
+				result.add(o, false, "field %s renamed to %s", o.Fields[i].Name, n.Fields[i].Name)
+			}
+		}
+		return
+	}
+
+	newFields := make(map[string]FieldSchema, len(n.Fields))
+	for _, field := range n.Fields {
+		newFields[field.Name] = field
+	}
+	oldFields := make(map[string]bool, len(o.Fields))
+	found := false
+	for _, field := range o.Fields {
+		oldFields[field.Name] = true
+		nf, ok := newFields[field.Name]
+		switch {
+		case !ok:
+			result.add(o, true, "field %s removed", field.Name)
+			found = true
+		case nf.Tag != field.Tag:
+			result.add(o, true, "field %s changed tag from %d to %d", field.Name, field.Tag, nf.Tag)
+			found = true
+		}
+	}
+	for _, field := range n.Fields {
+		if !oldFields[field.Name] {
+			result.add(o, true, "field %s added", field.Name)
+			found = true
+		}
+	}
+	if !found {
+		result.add(o, true, "fields reordered")
+	}
+}
+
+// compareImpls reports interface implementations removed from or added to o.
+func compareImpls(result *Compatibility, o, n TypeSchema) {
+	newImpls := make(map[byte]bool, len(n.Impls))
+	for _, tag := range n.Impls {
+		newImpls[tag] = true
+	}
+	oldImpls := make(map[byte]bool, len(o.Impls))
+	for _, tag := range o.Impls {
+		oldImpls[tag] = true
+		if !newImpls[tag] {
+			result.add(o, true, "implementation %d removed", tag)
+		}
+	}
+	for _, tag := range n.Impls {
+		if !oldImpls[tag] {
+			result.add(o, false, "implementation %d added", tag)
+		}
+	}
+}
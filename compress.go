@@ -0,0 +1,162 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Compressor compresses frame payloads. A compressed payload starts with the
+// compressor's ID so that the decoder can pick the matching decompressor.
+type Compressor interface {
+	// ID identifies the algorithm on the wire. IDs 1-63 are reserved for this package.
+	ID() byte
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// DefaultMaxDecompressedSize is the largest payload a Decoder inflates a compressed
+// frame to unless SetMaxDecompressedSize says otherwise.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// FlateCompressor compresses payloads with raw DEFLATE (compress/flate).
+// A zero Level means flate.DefaultCompression.
+type FlateCompressor struct {
+	Level int
+}
+
+func (c FlateCompressor) ID() byte { return 1 }
+
+func (c FlateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, compressionLevel(c.Level))
+}
+
+func (c FlateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// GzipCompressor compresses payloads with compress/gzip.
+// A zero Level means gzip.DefaultCompression.
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) ID() byte { return 2 }
+
+func (c GzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, compressionLevel(c.Level))
+}
+
+func (c GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ZlibCompressor compresses payloads with compress/zlib.
+// A zero Level means zlib.DefaultCompression.
+type ZlibCompressor struct {
+	Level int
+}
+
+func (c ZlibCompressor) ID() byte { return 3 }
+
+func (c ZlibCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, compressionLevel(c.Level))
+}
+
+func (c ZlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// compressionLevel maps the zero Level to the default; storing without compression
+// is never useful for a frame compressor.
+func compressionLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+// builtinCompressors returns the compressors every Decoder recognizes, by ID.
+func builtinCompressors() map[byte]Compressor {
+	compressors := make(map[byte]Compressor)
+	for _, c := range []Compressor{FlateCompressor{}, GzipCompressor{}, ZlibCompressor{}} {
+		compressors[c.ID()] = c
+	}
+	return compressors
+}
+
+// compressPayload returns the compressor ID followed by the compressed payload.
+func compressPayload(c Compressor, payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(c.ID())
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressPayload is the inverse of compressPayload. It fails once the output
+// exceeds limit bytes instead of inflating the whole payload.
+func decompressPayload(compressors map[byte]Compressor, data []byte, limit int) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("compressed payload is empty")
+	}
+	c, ok := compressors[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor ID %d", data[0])
+	}
+	r, err := c.NewReader(bytes.NewReader(data[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds the limit of %d bytes", limit)
+	}
+	return out, nil
+}
+
+// SetCompression makes the encoder compress payloads of at least threshold bytes
+// with c. Compressed frames use the versioned frame header with FlagCompressed set;
+// payloads below the threshold, or that do not shrink, are sent as they are.
+// A nil c disables compression.
+func (e *Encoder) SetCompression(c Compressor, threshold int) {
+	e.compressor = c
+	e.compressThreshold = threshold
+}
+
+// RegisterCompressor lets the decoder read frames compressed with c in addition to
+// the built-in flate, gzip and zlib compressors.
+func (d *Decoder) RegisterCompressor(c Compressor) {
+	d.compressors[c.ID()] = c
+}
+
+// SetMaxDecompressedSize limits how large a compressed payload may become when
+// inflated, to guard against zip bombs. The default is DefaultMaxDecompressedSize.
+// Zero or less restores the default.
+func (d *Decoder) SetMaxDecompressedSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxDecompressedSize
+	}
+	d.maxDecompressed = n
+}
+
+// decompress inflates a payload from a frame with FlagCompressed set.
+func (d *Decoder) decompress(payload []byte) ([]byte, error) {
+	return decompressPayload(d.compressors, payload, d.maxDecompressed)
+}
@@ -0,0 +1,50 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMaxDecompressedSize(t *testing.T) {
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	encoder := NewEncoder(registry)
+	encoder.SetCompression(ZlibCompressor{}, 64)
+	value := strings.Repeat("cryo", 1000)
+	frame, err := encoder.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		wantErr bool
+	}{
+		{name: "default", limit: DefaultMaxDecompressedSize},
+		{name: "large enough", limit: 8 << 10},
+		{name: "too small", limit: 100, wantErr: true},
+		{name: "zero restores the default", limit: 0},
+		{name: "negative restores the default", limit: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := NewDecoder(registry, bytes.NewReader(frame))
+			decoder.SetMaxDecompressedSize(tt.limit)
+			got, err := decoder.Decode()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != value {
+				t.Errorf("got %d bytes, want %d", len(got.(string)), len(value))
+			}
+		})
+	}
+}
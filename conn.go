@@ -0,0 +1,544 @@
+package CryoDecoder
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default timings of a Conn.
+const (
+	DefaultPingInterval = 15 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+	DefaultCloseTimeout = 5 * time.Second
+)
+
+// Control frame kinds.
+const (
+	controlPing     byte = iota + 1 // Value is the sender's clock in nanoseconds
+	controlPong                     // Value echoes the ping's
+	controlClose                    // the sender has flushed its frames and wants to close
+	controlCloseAck                 // the sender has flushed its frames too
+)
+
+// controlFrame is the frame Conns use among themselves (tag 47). It never reaches
+// Recv.
+type controlFrame struct {
+	Kind  byte
+	Value int64
+}
+
+// controlFrameCodec handles controlFrame as the kind byte followed by the value as a
+// varint.
+type controlFrameCodec struct{}
+
+func (c *controlFrameCodec) Encode(value interface{}) ([]byte, error) {
+	f, ok := value.(controlFrame)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not controlFrame", value)
+	}
+	return binary.AppendVarint([]byte{f.Kind}, f.Value), nil
+}
+
+func (c *controlFrameCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("invalid data length for control frame: %d", len(data))
+	}
+	value, n := binary.Varint(data[1:])
+	if n <= 0 || 1+n != len(data) {
+		return nil, fmt.Errorf("invalid control frame value")
+	}
+	return controlFrame{Kind: data[0], Value: value}, nil
+}
+
+// ConnState is the state of a Conn.
+type ConnState int32
+
+const (
+	ConnOpen    ConnState = iota // frames can be sent and received
+	ConnClosing                  // pending frames are being flushed; Send fails
+	ConnClosed                   // the connection is closed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnOpen:
+		return "open"
+	case ConnClosing:
+		return "closing"
+	case ConnClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int32(s))
+}
+
+var (
+	// ErrConnClosed is returned by Send once Close has been called or the peer has
+	// started closing.
+	ErrConnClosed = errors.New("connection closed")
+	// ErrPeerUnresponsive means nothing, not even a pong, arrived within the read
+	// timeout, so the peer or the path to it is presumed gone.
+	ErrPeerUnresponsive = errors.New("peer unresponsive")
+	// ErrIdleTimeout means the connection was closed because no frames other than
+	// pings were sent or received within the idle timeout.
+	ErrIdleTimeout = errors.New("connection idle")
+)
+
+// ConnConfig configures a Conn. Zero values select the defaults.
+type ConnConfig struct {
+	// PingInterval is how often a ping is sent. Negative disables pings.
+	PingInterval time.Duration
+	// ReadTimeout is how long to wait for any frame before giving up on the peer with
+	// ErrPeerUnresponsive. It defaults to three ping intervals, or no timeout if pings
+	// are disabled.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds every write to the connection. Negative disables it. A write
+	// that times out is resumed as long as frames from the peer arrive within the read
+	// timeout, since a peer that is alive but not reading is only slow.
+	WriteTimeout time.Duration
+	// IdleTimeout closes the connection gracefully once no frames other than pings
+	// were sent or received for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// CloseTimeout bounds how long Close waits for the peer to flush and acknowledge.
+	CloseTimeout time.Duration
+	// Configure, if set, is called with the Conn's Encoder and Decoder before any frame
+	// is sent or received, for example to enable signing or encryption.
+	Configure func(encoder *Encoder, decoder *Decoder)
+	// OnStateChange is called, from the Conn's goroutines, whenever the state changes.
+	// For ConnClosed, err is why: nil after a graceful close.
+	OnStateChange func(state ConnState, err error)
+}
+
+// Conn exchanges frames over a net.Conn and keeps the connection healthy: it sends
+// pings so that half-open connections are noticed, applies read and write deadlines,
+// closes idle connections and closes gracefully, delivering every frame either side
+// sent before closing. Both ends must use a Conn.
+//
+// Received frames wait for Recv in a bounded queue. While it is full the Conn stops
+// reading, so a peer that keeps sending is slowed down: its writes block. As long as
+// the peer still receives this side's pings it keeps waiting rather than timing out,
+// so an application that falls behind on Recv does not lose the connection.
+type Conn struct {
+	conn    net.Conn
+	config  ConnConfig
+	encMu   sync.Mutex // Guards encoder
+	encoder *Encoder
+	decoder *Decoder
+
+	sendMu   sync.RWMutex      // Held for reading while queueing; for writing to stop Send
+	outgoing chan queuedFrame  // Application frames, in order
+	control  chan controlFrame // Control frames, sent ahead of application frames
+	flush    chan byte         // Receives the control frame kind to send once flushed
+	incoming chan interface{}
+
+	mu        sync.Mutex // Guards state and err
+	state     ConnState
+	err       error
+	done      chan struct{}
+	closeSent chan struct{} // Closed once our close frame, after every queued frame, is written
+	acked     chan struct{} // Closed when the peer acknowledges our close
+	ackSent   atomic.Bool   // Set once every queued frame is written, just before our ack of the peer's close
+	lastUsed  atomic.Int64  // Unix nanoseconds of the last application frame
+	lastRecv  atomic.Int64  // Unix nanoseconds of the last frame of any kind received
+	rtt       atomic.Int64
+}
+
+// queuedFrame is an application frame waiting for the writer. Its payload is encoded
+// when it is queued, so that Send can report encoding errors, but it is framed, and
+// so compressed, encrypted and signed, only when written: control frames overtake
+// queued ones, and signed frames must be numbered in the order they are written.
+type queuedFrame struct {
+	tag     byte
+	payload []byte
+}
+
+// NewConn wraps conn and starts its reader, writer and heartbeat goroutines. The
+// registry must have the primitives registered (tag 47 carries the control frames),
+// and since the goroutines encode and decode concurrently, every type should be
+// registered before calling NewConn.
+func NewConn(registry *CodecRegistry, conn net.Conn, config ConnConfig) *Conn {
+	if config.PingInterval == 0 {
+		config.PingInterval = DefaultPingInterval
+	}
+	if config.ReadTimeout == 0 && config.PingInterval > 0 {
+		config.ReadTimeout = 3 * config.PingInterval
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = DefaultCloseTimeout
+	}
+
+	c := &Conn{
+		conn:      conn,
+		config:    config,
+		encoder:   NewEncoder(registry),
+		decoder:   NewDecoder(registry, bufio.NewReader(conn)),
+		outgoing:  make(chan queuedFrame, 64),
+		control:   make(chan controlFrame, 16),
+		flush:     make(chan byte, 1),
+		incoming:  make(chan interface{}, 64),
+		done:      make(chan struct{}),
+		closeSent: make(chan struct{}),
+		acked:     make(chan struct{}),
+	}
+	if config.Configure != nil {
+		config.Configure(c.encoder, c.decoder)
+	}
+	c.lastUsed.Store(time.Now().UnixNano())
+	c.lastRecv.Store(time.Now().UnixNano())
+	go c.readLoop()
+	go c.writeLoop()
+	if config.PingInterval > 0 || config.IdleTimeout > 0 {
+		go c.heartbeat()
+	}
+	return c
+}
+
+// State returns the current state.
+func (c *Conn) State() ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Err returns why the connection closed: nil while it is open and after a graceful
+// close.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Done is closed once the connection is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// RTT returns the round-trip time measured by the last ping, or 0 before the first
+// pong arrives.
+func (c *Conn) RTT() time.Duration {
+	return time.Duration(c.rtt.Load())
+}
+
+// Send encodes value and queues it. Frames are written in the order they were sent.
+func (c *Conn) Send(value interface{}) error {
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.State() != ConnOpen {
+		return ErrConnClosed
+	}
+	c.encMu.Lock()
+	tag, payload, err := encodeTagged(c.encoder.registry, value)
+	c.encMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding failed: %w", err)
+	}
+	select {
+	case c.outgoing <- queuedFrame{tag: tag, payload: payload}:
+		c.lastUsed.Store(time.Now().UnixNano())
+		return nil
+	case <-c.done:
+		return ErrConnClosed
+	}
+}
+
+// Recv returns the next frame from the peer. Once the connection is closed and every
+// frame received before has been returned, it returns io.EOF after a graceful close,
+// or the error the connection failed with.
+func (c *Conn) Recv() (interface{}, error) {
+	select {
+	case value := <-c.incoming:
+		return value, nil
+	case <-c.done:
+		select {
+		case value := <-c.incoming:
+			return value, nil
+		default:
+		}
+		if err := c.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
+
+// Close closes the connection gracefully: frames already passed to Send are written,
+// then the peer is asked to flush its own and acknowledge. Close waits for that, up
+// to the close timeout, and then closes the connection.
+func (c *Conn) Close() error {
+	c.shutdown(controlClose, nil)
+	timer := time.NewTimer(c.config.CloseTimeout)
+	defer timer.Stop()
+	// Both are needed: when the peer closes at the same time, its close frame acts as
+	// the acknowledgement and may arrive before our own queue is flushed.
+	for sent, acked := c.closeSent, c.acked; sent != nil || acked != nil; {
+		select {
+		case <-sent:
+			sent = nil
+		case <-acked:
+			acked = nil
+		case <-c.done:
+			return c.Err()
+		case <-timer.C:
+			c.finish(fmt.Errorf("%w: peer did not acknowledge the close within %v", ErrConnClosed, c.config.CloseTimeout))
+			return c.Err()
+		}
+	}
+	c.finish(nil)
+	return c.Err()
+}
+
+// shutdown stops Send and makes the writer send kind once the queued frames are
+// written. reason becomes the error the connection closes with.
+func (c *Conn) shutdown(kind byte, reason error) {
+	c.sendMu.Lock()
+	c.mu.Lock()
+	if c.state != ConnOpen {
+		c.mu.Unlock()
+		c.sendMu.Unlock()
+		return
+	}
+	c.state, c.err = ConnClosing, reason
+	c.mu.Unlock()
+	c.sendMu.Unlock()
+
+	c.notify(ConnClosing, nil)
+	c.flush <- kind
+}
+
+// finish closes the connection with err unless it is already closed.
+func (c *Conn) finish(err error) {
+	c.mu.Lock()
+	if c.state == ConnClosed {
+		c.mu.Unlock()
+		return
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	c.state, err = ConnClosed, c.err
+	close(c.done)
+	c.mu.Unlock()
+
+	c.conn.Close()
+	c.notify(ConnClosed, err)
+}
+
+func (c *Conn) notify(state ConnState, err error) {
+	if c.config.OnStateChange != nil {
+		c.config.OnStateChange(state, err)
+	}
+}
+
+// sendControl queues a control frame, dropping it if the control queue is full.
+func (c *Conn) sendControl(kind byte, value int64) {
+	select {
+	case c.control <- controlFrame{Kind: kind, Value: value}:
+	default:
+	}
+}
+
+// writeControl frames and writes a control frame.
+func (c *Conn) writeControl(f controlFrame) error {
+	c.encMu.Lock()
+	frame, err := c.encoder.EncodeAs(47, f)
+	c.encMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot encode control frame: %w", err)
+	}
+	return c.write(frame)
+}
+
+// writeQueued frames and writes an application frame.
+func (c *Conn) writeQueued(q queuedFrame) error {
+	c.encMu.Lock()
+	frame, err := c.encoder.encodeFrame(q.tag, q.payload)
+	c.encMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.write(frame)
+}
+
+// write writes frame within the write timeout. A write that times out while frames,
+// such as pings, still arrive from the peer is resumed: the peer is alive and only
+// its application is not reading.
+func (c *Conn) write(frame []byte) error {
+	for {
+		if c.config.WriteTimeout > 0 {
+			c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+		}
+		n, err := c.conn.Write(frame)
+		frame = frame[n:]
+		var netErr net.Error
+		if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() || !c.peerAlive() {
+			return err
+		}
+	}
+}
+
+// peerAlive reports whether a frame arrived from the peer within the read timeout.
+func (c *Conn) peerAlive() bool {
+	return c.config.ReadTimeout > 0 && time.Since(time.Unix(0, c.lastRecv.Load())) < c.config.ReadTimeout
+}
+
+func (c *Conn) writeLoop() {
+	for {
+		var err error
+		select {
+		case f := <-c.control:
+			err = c.writeControl(f)
+		default:
+			select {
+			case f := <-c.control:
+				err = c.writeControl(f)
+			case q := <-c.outgoing:
+				err = c.writeQueued(q)
+			case kind := <-c.flush:
+				c.flushAndClose(kind)
+				return
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			c.finish(fmt.Errorf("write failed: %w", err))
+			return
+		}
+	}
+}
+
+// flushAndClose writes every queued frame and then the close frame. After a close
+// acknowledgement nothing more is sent, so the connection is closed straight away.
+func (c *Conn) flushAndClose(kind byte) {
+	for {
+		var err error
+		select {
+		case f := <-c.control:
+			err = c.writeControl(f)
+		case q := <-c.outgoing:
+			err = c.writeQueued(q)
+		default:
+			if kind == controlCloseAck {
+				// The peer may hang up as soon as the ack arrives, before this
+				// goroutine gets to finish.
+				c.ackSent.Store(true)
+			}
+			if err := c.writeControl(controlFrame{Kind: kind}); err != nil {
+				c.finish(fmt.Errorf("cannot send close: %w", err))
+			} else if kind == controlCloseAck {
+				c.finish(nil)
+			} else {
+				close(c.closeSent)
+			}
+			return
+		}
+		if err != nil {
+			c.finish(fmt.Errorf("write failed: %w", err))
+			return
+		}
+	}
+}
+
+func (c *Conn) readLoop() {
+	for {
+		if c.config.ReadTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
+		}
+		tag, value, err := c.decoder.DecodeTagged()
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				err = ErrPeerUnresponsive
+			case (c.isAcked() || c.ackSent.Load()) && (errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)):
+				// The peer closed after acknowledging our close, or after we
+				// acknowledged its own.
+				err = nil
+			case errors.Is(err, io.EOF):
+				err = fmt.Errorf("%w: peer closed the connection without a close handshake", ErrConnClosed)
+			}
+			c.finish(err)
+			return
+		}
+		c.lastRecv.Store(time.Now().UnixNano())
+
+		f, ok := value.(controlFrame)
+		if tag != 47 || !ok {
+			c.lastUsed.Store(time.Now().UnixNano())
+			select {
+			case c.incoming <- value:
+			case <-c.done:
+				return
+			}
+			continue
+		}
+		switch f.Kind {
+		case controlPing:
+			c.sendControl(controlPong, f.Value)
+		case controlPong:
+			c.rtt.Store(max(time.Now().UnixNano()-f.Value, 0))
+		case controlClose:
+			if c.State() == ConnClosing {
+				// Both sides closed at once: each close acknowledges the other.
+				c.closeAcked()
+			} else {
+				c.shutdown(controlCloseAck, nil)
+			}
+		case controlCloseAck:
+			c.closeAcked()
+		}
+	}
+}
+
+func (c *Conn) closeAcked() {
+	if !c.isAcked() {
+		close(c.acked)
+	}
+}
+
+func (c *Conn) isAcked() bool {
+	select {
+	case <-c.acked:
+		return true
+	default:
+		return false
+	}
+}
+
+// heartbeat sends pings and closes the connection once it has been idle too long.
+func (c *Conn) heartbeat() {
+	interval := c.config.PingInterval
+	if c.config.IdleTimeout > 0 && (interval <= 0 || c.config.IdleTimeout/4 < interval) {
+		interval = max(c.config.IdleTimeout/4, time.Millisecond)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastPing := time.Now()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if c.config.PingInterval > 0 && now.Sub(lastPing) >= c.config.PingInterval {
+				c.sendControl(controlPing, now.UnixNano())
+				lastPing = now
+			}
+			idle := now.Sub(time.Unix(0, c.lastUsed.Load()))
+			if c.config.IdleTimeout > 0 && idle >= c.config.IdleTimeout && c.State() == ConnOpen {
+				go func() {
+					c.shutdown(controlClose, ErrIdleTimeout)
+					c.Close()
+				}()
+			}
+		}
+	}
+}
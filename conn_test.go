@@ -0,0 +1,267 @@
+package CryoDecoder
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newConnRegistry() *CodecRegistry {
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	return registry
+}
+
+func TestConnSlowReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ConnConfig
+		wantErr bool // whether the sender gives up on the reader
+	}{
+		{name: "pings keep the sender waiting", config: ConnConfig{PingInterval: 20 * time.Millisecond, WriteTimeout: 50 * time.Millisecond}},
+		{name: "without pings the write times out", config: ConnConfig{PingInterval: -1, WriteTimeout: 50 * time.Millisecond}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			reader := NewConn(newConnRegistry(), a, tt.config)
+			sender := NewConn(newConnRegistry(), b, tt.config)
+			defer reader.Close()
+			defer sender.Close()
+
+			const frames = 200 // more than the queues on both sides hold
+			sent := make(chan error, 1)
+			go func() {
+				for i := 0; i < frames; i++ {
+					if err := sender.Send(int32(i)); err != nil {
+						sent <- err
+						return
+					}
+				}
+				sent <- nil
+			}()
+
+			// The reader's application falls behind for several write timeouts.
+			time.Sleep(300 * time.Millisecond)
+			if tt.wantErr {
+				if err := <-sent; err == nil {
+					t.Fatal("expected Send to fail")
+				}
+				return
+			}
+			for i := 0; i < frames; i++ {
+				value, err := reader.Recv()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if value != int32(i) {
+					t.Fatalf("got %v, want %d", value, i)
+				}
+			}
+			if err := <-sent; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// waitDone fails the test unless c closes in time.
+func waitDone(t *testing.T, c *Conn) {
+	t.Helper()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection did not close")
+	}
+}
+
+func TestConnHeartbeat(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ConnConfig
+		peerConfig ConnConfig
+		silentPeer bool          // the peer reads but never answers, as if half-open
+		traffic    time.Duration // send a frame this often while waiting; zero for none
+		wait       time.Duration // how long the connection must stay open; zero if it closes
+		wantErr    error
+		wantRTT    bool
+	}{
+		{
+			name:       "pings measure the round trip",
+			config:     ConnConfig{PingInterval: 10 * time.Millisecond},
+			peerConfig: ConnConfig{PingInterval: -1},
+			wait:       200 * time.Millisecond,
+			wantRTT:    true,
+		},
+		{
+			name:       "pongs keep a quiet connection open",
+			config:     ConnConfig{PingInterval: 10 * time.Millisecond, ReadTimeout: 50 * time.Millisecond},
+			peerConfig: ConnConfig{PingInterval: -1},
+			wait:       300 * time.Millisecond,
+			wantRTT:    true,
+		},
+		{
+			name:       "silent peer is unresponsive",
+			config:     ConnConfig{PingInterval: 10 * time.Millisecond, ReadTimeout: 50 * time.Millisecond},
+			silentPeer: true,
+			wantErr:    ErrPeerUnresponsive,
+		},
+		{
+			name:       "pings do not keep an idle connection open",
+			config:     ConnConfig{PingInterval: 10 * time.Millisecond, IdleTimeout: 50 * time.Millisecond},
+			peerConfig: ConnConfig{PingInterval: 10 * time.Millisecond},
+			wantErr:    ErrIdleTimeout,
+		},
+		{
+			name:       "traffic keeps a connection from idling",
+			config:     ConnConfig{PingInterval: -1, IdleTimeout: 100 * time.Millisecond},
+			peerConfig: ConnConfig{PingInterval: -1},
+			traffic:    10 * time.Millisecond,
+			wait:       300 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			c := NewConn(newConnRegistry(), a, tt.config)
+			defer c.Close()
+			var peer *Conn
+			if tt.silentPeer {
+				go io.Copy(io.Discard, b)
+				defer b.Close()
+			} else {
+				peer = NewConn(newConnRegistry(), b, tt.peerConfig)
+				defer peer.Close()
+				go func() {
+					for {
+						if _, err := peer.Recv(); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			if tt.traffic > 0 {
+				ticker := time.NewTicker(tt.traffic)
+				defer ticker.Stop()
+				go func() {
+					for range ticker.C {
+						if c.Send(int32(1)) != nil {
+							return
+						}
+					}
+				}()
+			}
+
+			if tt.wait == 0 {
+				waitDone(t, c)
+				if err := c.Err(); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("closed with %v, want %v", err, tt.wantErr)
+				}
+				if _, err := c.Recv(); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Recv: got %v, want %v", err, tt.wantErr)
+				}
+				if peer != nil {
+					// The peer saw a graceful close.
+					waitDone(t, peer)
+					if err := peer.Err(); err != nil {
+						t.Fatalf("peer closed with %v", err)
+					}
+				}
+				return
+			}
+			select {
+			case <-c.Done():
+				t.Fatalf("closed with %v", c.Err())
+			case <-time.After(tt.wait):
+			}
+			if got := c.RTT(); (got > 0) != tt.wantRTT {
+				t.Fatalf("RTT is %v", got)
+			}
+		})
+	}
+}
+
+func TestConnGracefulClose(t *testing.T) {
+	tests := []struct {
+		name      string
+		frames    int  // sent by each side before closing
+		closeBoth bool // both sides call Close at once
+	}{
+		{name: "one side closes", frames: 100},
+		{name: "both sides close at once", frames: 100, closeBoth: true},
+		{name: "nothing queued", closeBoth: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			config := ConnConfig{PingInterval: -1, CloseTimeout: 2 * time.Second}
+			conns := []*Conn{NewConn(newConnRegistry(), a, config), NewConn(newConnRegistry(), b, config)}
+
+			// Each side receives concurrently, since more frames are sent than the
+			// queues hold.
+			received := make([]chan error, len(conns))
+			for i, c := range conns {
+				received[i] = make(chan error, 1)
+				go func() {
+					for n := 0; ; n++ {
+						value, err := c.Recv()
+						switch {
+						case err == io.EOF && n == tt.frames:
+							received[i] <- nil
+							return
+						case err != nil:
+							received[i] <- err
+							return
+						case value != int32(n):
+							received[i] <- errors.New("frame out of order")
+							return
+						}
+					}
+				}()
+			}
+			for n := 0; n < tt.frames; n++ {
+				for _, c := range conns {
+					if err := c.Send(int32(n)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			closers := conns[:1]
+			if tt.closeBoth {
+				closers = conns
+			}
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			closed := make([]error, len(closers))
+			for i, c := range closers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					closed[i] = c.Close()
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			for i, err := range closed {
+				if err != nil {
+					t.Errorf("Close %d: %v", i, err)
+				}
+			}
+			for i, c := range conns {
+				waitDone(t, c)
+				if err := c.Err(); err != nil {
+					t.Errorf("side %d closed with %v", i, err)
+				}
+				if err := <-received[i]; err != nil {
+					t.Errorf("side %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultDatagramMTU is the largest datagram a DatagramConn sends unless told
+// otherwise. It fits in the path MTU of practically every network, so datagrams are
+// not fragmented again by IP.
+const DefaultDatagramMTU = 1200
+
+// DefaultReassemblyTimeout is how long a DatagramConn keeps the fragments of a
+// message that is still incomplete before dropping them.
+const DefaultReassemblyTimeout = 5 * time.Second
+
+// DefaultMaxDatagramMessage is the largest message a DatagramConn reassembles unless
+// told otherwise. Fragments of all incomplete messages together may hold up to
+// reassemblyBudget times this much.
+const DefaultMaxDatagramMessage = DefaultMaxFrameSize
+
+// Every datagram starts with datagramMarker and a kind. Fragments continue with the
+// flags, the sender's epoch and the message ID (4 bytes each), the fragment index and
+// the fragment count (2 bytes each) and the fragment data; acks continue with the
+// epoch and ID of the message. The epoch is random per DatagramConn, so a restarted
+// sender's IDs never collide with those the receiver remembers from before.
+const (
+	datagramMarker byte = 0xAE
+
+	datagramFragment byte = 1
+	datagramAck      byte = 2
+
+	datagramReliable byte = 1 // the sender waits for an ack of the message
+
+	fragmentHeaderSize = 2 + 1 + 4 + 4 + 2 + 2
+	ackSize            = 2 + 4 + 4
+)
+
+const (
+	// datagramQueue is the number of complete messages waiting for Recv. Once it is
+	// full, further messages are dropped; reliable ones are not acked, so they are
+	// retransmitted.
+	datagramQueue = 256
+	// maxPartialMessages bounds the messages being reassembled at once; the oldest is
+	// dropped to make room.
+	maxPartialMessages = 64
+	// deliveredHistory is the number of reliable message IDs remembered, so that a
+	// retransmission of a message already delivered is acked but not delivered again.
+	deliveredHistory = 1024
+	// reassemblyBudget is the number of maximum-size messages the fragments of all
+	// incomplete messages may add up to; the oldest messages are dropped to make room.
+	reassemblyBudget = 4
+)
+
+// messageKey identifies a message by the sender's epoch and the message ID.
+type messageKey struct {
+	epoch, id uint32
+}
+
+// ErrNotAcknowledged is returned by DatagramConn.Send in reliable mode when the peer
+// did not acknowledge a message after every retransmission.
+var ErrNotAcknowledged = errors.New("message not acknowledged")
+
+// partialMessage collects the fragments of one message. Every fragment but the last
+// has the same size, which is learned from the first of them to arrive.
+type partialMessage struct {
+	fragments map[int][]byte
+	count     int
+	size      int // Data size of every fragment but the last; 0 until one arrives
+	received  int // Data bytes received, checked against the message size limit
+	held      int // Bytes counted against the reassembly budget
+	reliable  bool
+	started   time.Time
+}
+
+// DatagramConn sends and receives frames over a packet connection such as UDP. Frames
+// larger than the MTU are split into numbered fragments and reassembled by the peer;
+// a message whose fragments do not all arrive within the reassembly timeout is
+// dropped. By default messages are sent once and may be lost, duplicated or reordered.
+// In reliable mode (SetReliable) Send waits for the peer to acknowledge the message
+// and retransmits it until it does. Both peers must use the same mode.
+type DatagramConn struct {
+	conn net.PacketConn
+	peer net.Addr
+
+	sendMu  sync.Mutex // Guards encoder and nextID
+	encoder *Encoder
+	epoch   uint32
+	nextID  uint32
+
+	configMu          sync.Mutex
+	mtu               int
+	maxMessage        int
+	reassemblyTimeout time.Duration
+	reliable          bool
+	retransmit        time.Duration
+	attempts          int
+
+	decoderMu sync.Mutex // Guards decoder and frame
+	decoder   *Decoder
+	frame     bytes.Buffer // The decoder's input: one reassembled frame at a time
+
+	ackMu sync.Mutex
+	acks  map[uint32]chan struct{} // Reliable messages waiting for their ack
+
+	// Owned by readLoop
+	partial   map[messageKey]*partialMessage
+	held      int // Sum of held over partial
+	delivered map[messageKey]bool
+	history   []messageKey
+
+	queue chan []byte
+	done  chan struct{}
+	err   error // Set before done is closed
+}
+
+// NewDatagramConn returns a DatagramConn that exchanges frames with peer over conn and
+// starts reading from conn. Datagrams from other addresses are ignored.
+func NewDatagramConn(registry *CodecRegistry, conn net.PacketConn, peer net.Addr) *DatagramConn {
+	var epoch [4]byte
+	rand.Read(epoch[:])
+	c := &DatagramConn{
+		conn:              conn,
+		peer:              peer,
+		encoder:           NewEncoder(registry),
+		epoch:             binary.BigEndian.Uint32(epoch[:]),
+		mtu:               DefaultDatagramMTU,
+		maxMessage:        DefaultMaxDatagramMessage,
+		reassemblyTimeout: DefaultReassemblyTimeout,
+		acks:              make(map[uint32]chan struct{}),
+		partial:           make(map[messageKey]*partialMessage),
+		delivered:         make(map[messageKey]bool),
+		queue:             make(chan []byte, datagramQueue),
+		done:              make(chan struct{}),
+	}
+	c.decoder = NewDecoder(registry, &c.frame)
+	go c.readLoop()
+	return c
+}
+
+// Encoder returns the Encoder used by Send, for example to sign frames. Signing with a
+// replay window on the Decoder also discards duplicated datagrams.
+func (c *DatagramConn) Encoder() *Encoder {
+	return c.encoder
+}
+
+// Decoder returns the Decoder used by Recv.
+func (c *DatagramConn) Decoder() *Decoder {
+	return c.decoder
+}
+
+// SetMTU sets the largest datagram sent, headers included. Zero restores
+// DefaultDatagramMTU.
+func (c *DatagramConn) SetMTU(mtu int) error {
+	if mtu == 0 {
+		mtu = DefaultDatagramMTU
+	}
+	if mtu <= fragmentHeaderSize {
+		return fmt.Errorf("MTU %d leaves no room for data after the %d-byte fragment header", mtu, fragmentHeaderSize)
+	}
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.mtu = mtu
+	return nil
+}
+
+// SetMaxMessageSize sets the largest message reassembled from fragments. A message
+// whose fragments add up to more, or whose fragment count times fragment size does,
+// is dropped as soon as that is known. Fragments of all incomplete messages together
+// are limited to four times this size. Zero or less restores DefaultMaxDatagramMessage.
+func (c *DatagramConn) SetMaxMessageSize(n int) {
+	if n <= 0 {
+		n = DefaultMaxDatagramMessage
+	}
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.maxMessage = n
+}
+
+// SetReassemblyTimeout sets how long fragments of an incomplete message are kept.
+// Zero restores DefaultReassemblyTimeout.
+func (c *DatagramConn) SetReassemblyTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultReassemblyTimeout
+	}
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.reassemblyTimeout = timeout
+}
+
+// SetReliable makes Send wait for an acknowledgement, sending the message again every
+// interval (100ms if zero) up to attempts times in all before giving up with
+// ErrNotAcknowledged. An attempts of zero disables reliable mode.
+func (c *DatagramConn) SetReliable(interval time.Duration, attempts int) {
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.reliable, c.retransmit, c.attempts = attempts > 0, interval, attempts
+}
+
+// Send encodes value and sends it as one or more datagrams. In reliable mode it
+// returns once the peer has acknowledged the message.
+func (c *DatagramConn) Send(value interface{}) error {
+	c.configMu.Lock()
+	mtu, reliable, interval, attempts := c.mtu, c.reliable, c.retransmit, c.attempts
+	c.configMu.Unlock()
+
+	c.sendMu.Lock()
+	frame, err := c.encoder.Encode(value)
+	c.nextID++
+	id := c.nextID
+	c.sendMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var flags byte
+	if reliable {
+		flags |= datagramReliable
+	}
+	datagrams, err := fragment(frame, messageKey{c.epoch, id}, flags, mtu)
+	if err != nil {
+		return err
+	}
+	if !reliable {
+		return c.write(datagrams)
+	}
+
+	acked := make(chan struct{})
+	c.ackMu.Lock()
+	c.acks[id] = acked
+	c.ackMu.Unlock()
+	defer func() {
+		c.ackMu.Lock()
+		delete(c.acks, id)
+		c.ackMu.Unlock()
+	}()
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err := c.write(datagrams); err != nil {
+			return err
+		}
+		timer.Reset(interval)
+		select {
+		case <-acked:
+			return nil
+		case <-c.done:
+			return c.err
+		case <-timer.C:
+		}
+	}
+	return fmt.Errorf("message %d: %w after %d attempts", id, ErrNotAcknowledged, attempts)
+}
+
+// fragment splits frame into datagrams of at most mtu bytes.
+func fragment(frame []byte, key messageKey, flags byte, mtu int) ([][]byte, error) {
+	size := mtu - fragmentHeaderSize
+	count := max((len(frame)+size-1)/size, 1)
+	if count > 1<<16-1 {
+		return nil, fmt.Errorf("frame of %d bytes needs %d fragments, more than the %d allowed", len(frame), count, 1<<16-1)
+	}
+	datagrams := make([][]byte, count)
+	for i := range datagrams {
+		chunk := frame[i*size : min((i+1)*size, len(frame))]
+		d := append(make([]byte, 0, fragmentHeaderSize+len(chunk)), datagramMarker, datagramFragment, flags)
+		d = binary.BigEndian.AppendUint32(d, key.epoch)
+		d = binary.BigEndian.AppendUint32(d, key.id)
+		d = binary.BigEndian.AppendUint16(d, uint16(i))
+		d = binary.BigEndian.AppendUint16(d, uint16(count))
+		datagrams[i] = append(d, chunk...)
+	}
+	return datagrams, nil
+}
+
+func (c *DatagramConn) write(datagrams [][]byte) error {
+	for _, d := range datagrams {
+		if _, err := c.conn.WriteTo(d, c.peer); err != nil {
+			return fmt.Errorf("failed to send datagram: %w", err)
+		}
+	}
+	return nil
+}
+
+// Recv waits for the next complete message and decodes it. Messages are returned in
+// the order they were completed, which need not be the order they were sent.
+func (c *DatagramConn) Recv() (interface{}, error) {
+	select {
+	case frame := <-c.queue:
+		return c.decode(frame)
+	case <-c.done:
+		// Return messages that arrived before the connection was closed.
+		select {
+		case frame := <-c.queue:
+			return c.decode(frame)
+		default:
+			return nil, c.err
+		}
+	}
+}
+
+func (c *DatagramConn) decode(frame []byte) (interface{}, error) {
+	c.decoderMu.Lock()
+	defer c.decoderMu.Unlock()
+	c.frame.Reset()
+	c.frame.Write(frame)
+	value, err := c.decoder.Decode()
+	c.frame.Reset()
+	return value, err
+}
+
+// Close closes the underlying connection. Pending Send and Recv calls return
+// net.ErrClosed.
+func (c *DatagramConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *DatagramConn) readLoop() {
+	buf := make([]byte, 1<<16)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.err = fmt.Errorf("datagram connection closed: %w", err)
+			close(c.done)
+			return
+		}
+		if addr.String() != c.peer.String() || n < ackSize || buf[0] != datagramMarker {
+			continue
+		}
+		switch buf[1] {
+		case datagramAck:
+			if binary.BigEndian.Uint32(buf[2:]) != c.epoch {
+				continue // An ack for a previous incarnation of this connection
+			}
+			id := binary.BigEndian.Uint32(buf[6:])
+			c.ackMu.Lock()
+			if acked, ok := c.acks[id]; ok {
+				close(acked)
+				delete(c.acks, id)
+			}
+			c.ackMu.Unlock()
+		case datagramFragment:
+			if n >= fragmentHeaderSize {
+				c.receiveFragment(buf[:n])
+			}
+		}
+	}
+}
+
+// receiveFragment stores a fragment and queues its message once it is complete.
+func (c *DatagramConn) receiveFragment(d []byte) {
+	reliable := d[2]&datagramReliable != 0
+	key := messageKey{epoch: binary.BigEndian.Uint32(d[3:]), id: binary.BigEndian.Uint32(d[7:])}
+	index, count := int(binary.BigEndian.Uint16(d[11:])), int(binary.BigEndian.Uint16(d[13:]))
+	if count == 0 || index >= count {
+		return
+	}
+	if reliable && c.delivered[key] {
+		// Our ack was lost: send it again.
+		c.ack(key)
+		return
+	}
+
+	c.configMu.Lock()
+	timeout, maxMessage := c.reassemblyTimeout, c.maxMessage
+	c.configMu.Unlock()
+	now := time.Now()
+	c.dropExpired(now, timeout)
+
+	m, ok := c.partial[key]
+	if !ok {
+		if len(c.partial) >= maxPartialMessages {
+			c.dropOldest()
+		}
+		m = &partialMessage{fragments: make(map[int][]byte), count: count, reliable: reliable, started: now}
+		c.partial[key] = m
+	}
+	if _, dup := m.fragments[index]; dup || m.count != count {
+		return
+	}
+
+	data := d[fragmentHeaderSize:]
+	if index < count-1 {
+		switch {
+		case m.size == 0:
+			// The first full-size fragment tells how large the message is at least.
+			last, haveLast := m.fragments[count-1]
+			if len(data) == 0 || (count-1)*len(data) > maxMessage || (haveLast && len(last) > len(data)) {
+				c.dropPartial(key)
+				return
+			}
+			m.size = len(data)
+		case len(data) != m.size:
+			return
+		}
+	} else if m.size != 0 && len(data) > m.size {
+		return
+	}
+
+	if m.received+len(data) > maxMessage {
+		c.dropPartial(key)
+		return
+	}
+	// The budget charges fragments their header too, so that tiny ones are not free.
+	cost := fragmentHeaderSize + len(data)
+	for c.held+cost > reassemblyBudget*maxMessage {
+		if c.dropOldest() == key {
+			return
+		}
+	}
+	m.fragments[index] = bytes.Clone(data)
+	m.received += len(data)
+	m.held += cost
+	c.held += cost
+	if len(m.fragments) < count {
+		return
+	}
+
+	c.dropPartial(key)
+	frame := make([]byte, 0, (count-1)*m.size+len(m.fragments[count-1]))
+	for i := 0; i < count; i++ {
+		frame = append(frame, m.fragments[i]...)
+	}
+	select {
+	case c.queue <- frame:
+	default:
+		return
+	}
+	if m.reliable {
+		c.remember(key)
+		c.ack(key)
+	}
+}
+
+func (c *DatagramConn) ack(key messageKey) {
+	d := binary.BigEndian.AppendUint32([]byte{datagramMarker, datagramAck}, key.epoch)
+	c.conn.WriteTo(binary.BigEndian.AppendUint32(d, key.id), c.peer)
+}
+
+// dropExpired drops messages that did not complete within timeout.
+func (c *DatagramConn) dropExpired(now time.Time, timeout time.Duration) {
+	for key, m := range c.partial {
+		if now.Sub(m.started) > timeout {
+			c.dropPartial(key)
+		}
+	}
+}
+
+// dropOldest drops the message that started reassembly first and returns its key.
+func (c *DatagramConn) dropOldest() messageKey {
+	var oldest messageKey
+	var started time.Time
+	for key, m := range c.partial {
+		if started.IsZero() || m.started.Before(started) {
+			oldest, started = key, m.started
+		}
+	}
+	c.dropPartial(oldest)
+	return oldest
+}
+
+// dropPartial forgets the fragments of an incomplete message.
+func (c *DatagramConn) dropPartial(key messageKey) {
+	if m, ok := c.partial[key]; ok {
+		c.held -= m.held
+		delete(c.partial, key)
+	}
+}
+
+// remember records a delivered reliable message, forgetting the oldest one once
+// deliveredHistory IDs are remembered.
+func (c *DatagramConn) remember(key messageKey) {
+	if len(c.history) == deliveredHistory {
+		delete(c.delivered, c.history[0])
+		c.history = c.history[1:]
+	}
+	c.delivered[key] = true
+	c.history = append(c.history, key)
+}
@@ -0,0 +1,346 @@
+package CryoDecoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newDatagramRegistry() *CodecRegistry {
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	return registry
+}
+
+func listenUDP(t *testing.T, addr string) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// datagramPair returns two DatagramConns talking to each other over loopback UDP.
+func datagramPair(t *testing.T) (*DatagramConn, *DatagramConn) {
+	t.Helper()
+	a, b := listenUDP(t, "127.0.0.1:0"), listenUDP(t, "127.0.0.1:0")
+	ca := NewDatagramConn(newDatagramRegistry(), a, b.LocalAddr())
+	cb := NewDatagramConn(newDatagramRegistry(), b, a.LocalAddr())
+	t.Cleanup(func() {
+		ca.Close()
+		cb.Close()
+	})
+	return ca, cb
+}
+
+// recvTimeout calls Recv, failing the test if nothing arrives in time.
+func recvTimeout(t *testing.T, c *DatagramConn) interface{} {
+	t.Helper()
+	type result struct {
+		value interface{}
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		value, err := c.Recv()
+		ch <- result{value, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestDatagramSenderRestart(t *testing.T) {
+	receiverConn := listenUDP(t, "127.0.0.1:0")
+	senderConn := listenUDP(t, "127.0.0.1:0")
+	senderAddr := senderConn.LocalAddr().String()
+
+	receiver := NewDatagramConn(newDatagramRegistry(), receiverConn, senderConn.LocalAddr())
+	receiver.SetReliable(20*time.Millisecond, 50)
+	defer receiver.Close()
+
+	for round := 0; round < 2; round++ {
+		if round > 0 {
+			// A restarted sender starts numbering its messages from 1 again.
+			senderConn = listenUDP(t, senderAddr)
+		}
+		sender := NewDatagramConn(newDatagramRegistry(), senderConn, receiverConn.LocalAddr())
+		sender.SetReliable(20*time.Millisecond, 50)
+		for i := int32(0); i < 3; i++ {
+			want := round*10 + int(i)
+			if err := sender.Send(int32(want)); err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+			if got := recvTimeout(t, receiver); got != int32(want) {
+				t.Fatalf("round %d: got %v, want %d", round, got, want)
+			}
+		}
+		sender.Close()
+	}
+}
+
+// rawFragment builds a fragment datagram by hand.
+func rawFragment(key messageKey, index, count int, data []byte) []byte {
+	d := []byte{datagramMarker, datagramFragment, 0}
+	d = binary.BigEndian.AppendUint32(d, key.epoch)
+	d = binary.BigEndian.AppendUint32(d, key.id)
+	d = binary.BigEndian.AppendUint16(d, uint16(index))
+	d = binary.BigEndian.AppendUint16(d, uint16(count))
+	return append(d, data...)
+}
+
+func TestDatagramReassemblyLimits(t *testing.T) {
+	registry := newDatagramRegistry()
+	frame, err := NewEncoder(registry).Encode(strings.Repeat("x", 1800))
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := NewEncoder(registry).Encode("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := func(sizes ...int) [][]byte {
+		var chunks [][]byte
+		rest := frame
+		for _, size := range sizes {
+			chunks = append(chunks, rest[:size])
+			rest = rest[size:]
+		}
+		return append(chunks, rest)
+	}
+
+	tests := []struct {
+		name       string
+		maxMessage int
+		send       func(key messageKey) [][]byte
+	}{
+		{
+			name:       "message over the size limit",
+			maxMessage: 1000,
+			send: func(key messageKey) [][]byte {
+				var datagrams [][]byte
+				for i, chunk := range split(600, 600) {
+					datagrams = append(datagrams, rawFragment(key, i, 3, chunk))
+				}
+				return datagrams
+			},
+		},
+		{
+			name: "fragment size differs from the first fragment",
+			send: func(key messageKey) [][]byte {
+				var datagrams [][]byte
+				for i, chunk := range split(600, 500) {
+					datagrams = append(datagrams, rawFragment(key, i, 3, chunk))
+				}
+				return datagrams
+			},
+		},
+		{
+			name:       "message evicted by the reassembly budget",
+			maxMessage: 2000,
+			send: func(key messageKey) [][]byte {
+				chunks := split(1000)
+				datagrams := [][]byte{rawFragment(key, 0, 2, chunks[0])}
+				// Incomplete messages from the same peer use up the budget.
+				for id := uint32(1); id <= 10; id++ {
+					flood := messageKey{epoch: key.epoch + 1, id: id}
+					datagrams = append(datagrams, rawFragment(flood, 0, 2, chunks[0]))
+				}
+				return append(datagrams, rawFragment(key, 1, 2, chunks[1]))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiverConn, peer := listenUDP(t, "127.0.0.1:0"), listenUDP(t, "127.0.0.1:0")
+			defer peer.Close()
+			receiver := NewDatagramConn(newDatagramRegistry(), receiverConn, peer.LocalAddr())
+			defer receiver.Close()
+			receiver.SetMaxMessageSize(tt.maxMessage)
+
+			key := messageKey{epoch: 7, id: 1}
+			datagrams := append(tt.send(key), rawFragment(messageKey{epoch: 7, id: 2}, 0, 1, small))
+			for _, d := range datagrams {
+				if _, err := peer.WriteTo(d, receiverConn.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Only the small message that follows may be delivered.
+			if got := recvTimeout(t, receiver); got != "ok" {
+				t.Fatalf("got %.20v, want the small message", got)
+			}
+		})
+	}
+}
+
+// faultyPacketConn passes every datagram written through fault, which returns the
+// datagrams to send in its place, so tests can drop, duplicate and reorder them.
+type faultyPacketConn struct {
+	net.PacketConn
+
+	mu     sync.Mutex
+	writes int
+	fault  func(i int, d []byte) [][]byte
+}
+
+func (c *faultyPacketConn) setFault(fault func(i int, d []byte) [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes, c.fault = 0, fault
+}
+
+func (c *faultyPacketConn) WriteTo(d []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	out := [][]byte{d}
+	if c.fault != nil {
+		out = c.fault(c.writes, d)
+	}
+	c.writes++
+	c.mu.Unlock()
+	for _, o := range out {
+		if _, err := c.PacketConn.WriteTo(o, addr); err != nil {
+			return 0, err
+		}
+	}
+	return len(d), nil
+}
+
+func TestDatagramDelivery(t *testing.T) {
+	large := strings.Repeat("fragmented ", 300)
+	drop := func(indexes ...int) func(int, []byte) [][]byte {
+		return func(i int, d []byte) [][]byte {
+			for _, index := range indexes {
+				if i == index {
+					return nil
+				}
+			}
+			return [][]byte{d}
+		}
+	}
+	reverse := func() func(int, []byte) [][]byte {
+		var held [][]byte
+		return func(_ int, d []byte) [][]byte {
+			held = append([][]byte{append([]byte(nil), d...)}, held...)
+			if len(held) < int(binary.BigEndian.Uint16(d[13:15])) {
+				return nil
+			}
+			out := held
+			held = nil
+			return out
+		}
+	}
+
+	tests := []struct {
+		name     string
+		mtu      int
+		attempts int // reliable mode if non-zero
+		value    string
+		fault    func(i int, d []byte) [][]byte // on the sender's datagrams
+		ackFault func(i int, d []byte) [][]byte // on the receiver's datagrams
+		wantErr  error
+		lost     bool
+	}{
+		{name: "single datagram", value: "hello"},
+		{name: "fragmented", mtu: 100, value: large},
+		{name: "fragments reordered", mtu: 100, value: large, fault: reverse()},
+		{
+			name:  "fragments duplicated",
+			mtu:   100,
+			value: large,
+			fault: func(_ int, d []byte) [][]byte { return [][]byte{d, d} },
+		},
+		{name: "fragment lost", mtu: 100, value: large, fault: drop(1), lost: true},
+		{name: "lost fragment retransmitted", mtu: 100, attempts: 5, value: large, fault: drop(1)},
+		{name: "lost ack retransmitted", mtu: 100, attempts: 5, value: large, ackFault: drop(0)},
+		{
+			name:     "every transmission lost",
+			mtu:      100,
+			attempts: 3,
+			value:    large,
+			fault:    func(int, []byte) [][]byte { return nil },
+			wantErr:  ErrNotAcknowledged,
+			lost:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &faultyPacketConn{PacketConn: listenUDP(t, "127.0.0.1:0"), fault: tt.fault}
+			b := &faultyPacketConn{PacketConn: listenUDP(t, "127.0.0.1:0"), fault: tt.ackFault}
+			sender := NewDatagramConn(newDatagramRegistry(), a, b.LocalAddr())
+			receiver := NewDatagramConn(newDatagramRegistry(), b, a.LocalAddr())
+			defer sender.Close()
+			defer receiver.Close()
+			if err := sender.SetMTU(tt.mtu); err != nil {
+				t.Fatal(err)
+			}
+			if tt.attempts > 0 {
+				sender.SetReliable(20*time.Millisecond, tt.attempts)
+			}
+
+			err := sender.Send(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Send: got %v, want %v", err, tt.wantErr)
+			}
+			if !tt.lost {
+				if got := recvTimeout(t, receiver); got != tt.value {
+					t.Fatalf("got %.20q, want %.20q", got, tt.value)
+				}
+			}
+
+			// The next message arrives, and nothing is delivered twice.
+			a.setFault(nil)
+			b.setFault(nil)
+			if err := sender.Send("next"); err != nil {
+				t.Fatal(err)
+			}
+			if got := recvTimeout(t, receiver); got != "next" {
+				t.Fatalf("got %.20q, want the next message", got)
+			}
+		})
+	}
+}
+
+func TestDatagramReassemblyTimeout(t *testing.T) {
+	receiverConn, peer := listenUDP(t, "127.0.0.1:0"), listenUDP(t, "127.0.0.1:0")
+	defer peer.Close()
+	receiver := NewDatagramConn(newDatagramRegistry(), receiverConn, peer.LocalAddr())
+	defer receiver.Close()
+	receiver.SetReassemblyTimeout(50 * time.Millisecond)
+
+	encoder := NewEncoder(newDatagramRegistry())
+	late, err := encoder.Encode("late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := encoder.Encode("next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(d []byte) {
+		t.Helper()
+		if _, err := peer.WriteTo(d, receiverConn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := messageKey{epoch: 7, id: 1}
+	send(rawFragment(key, 0, 2, late[:3]))
+	time.Sleep(200 * time.Millisecond)
+	// Once the first fragment has expired, the second one cannot complete the message.
+	send(rawFragment(key, 1, 2, late[3:]))
+	send(rawFragment(messageKey{epoch: 7, id: 2}, 0, 1, next))
+	if got := recvTimeout(t, receiver); got != "next" {
+		t.Fatalf("got %v, want the next message", got)
+	}
+}
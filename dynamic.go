@@ -0,0 +1,497 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// NewRegistryFromSchema builds a registry that can decode frames described by schema
+// without the Go types that produced them. Primitives decode to their usual Go values;
+// structs decode to map[string]interface{} keyed by field name, slices, arrays and sets
+// to []interface{}, maps to map[string]interface{} or map[interface{}]interface{}, and
+// pointers and nullable values to their element or nil. Marshaler-based types decode
+// to their raw payload: []byte for binary and gob, string for text and json.RawMessage
+// for JSON. Primitives this package does not know by name decode to []byte. The same generic values can be encoded again, which makes the registry
+// suitable for viewers and converters.
+func NewRegistryFromSchema(schema Schema) (*CodecRegistry, error) {
+	r := NewCodecRegistry()
+	r.RegisterPrimitives()
+
+	primitives := make(map[string]Codec)
+	for _, ts := range r.Schema().Types {
+		if ts.Kind == KindPrimitive {
+			primitives[ts.Name] = r.codecs[ts.Tag]
+		}
+	}
+
+	known := make(map[byte]bool, len(schema.Types))
+	for _, ts := range schema.Types {
+		known[ts.Tag] = true
+	}
+	checkRef := func(ts TypeSchema, ref byte, what string) error {
+		if !known[ref] {
+			return fmt.Errorf("schema tag %d (%s): %s refers to unknown tag %d", ts.Tag, ts.Name, what, ref)
+		}
+		return nil
+	}
+
+	for _, ts := range schema.Types {
+		var codec Codec
+		var err error
+		switch ts.Kind {
+		case KindPrimitive:
+			codec = primitives[ts.Name]
+			if codec == nil {
+				codec = onDemandPrimitive(ts.Name)
+			}
+		case KindStruct:
+			for _, field := range ts.Fields {
+				if err = checkRef(ts, field.Tag, "field "+field.Name); err != nil {
+					break
+				}
+			}
+			codec = &DynamicStructCodec{registry: r, name: ts.Name, fields: ts.Fields}
+		case KindSlice:
+			err = checkRef(ts, ts.Elem, "element")
+			codec = &DynamicSliceCodec{registry: r, elemTag: ts.Elem, arrayLen: -1}
+		case KindArray:
+			err = checkRef(ts, ts.Elem, "element")
+			codec = &DynamicSliceCodec{registry: r, elemTag: ts.Elem, arrayLen: ts.Len}
+		case KindMap:
+			if err = checkRef(ts, ts.Key, "key"); err == nil {
+				err = checkRef(ts, ts.Value, "value")
+			}
+			codec = &DynamicMapCodec{registry: r, keyTag: ts.Key, valTag: ts.Value}
+		case KindSet:
+			err = checkRef(ts, ts.Key, "key")
+			codec = &DynamicSetCodec{registry: r, keyTag: ts.Key}
+		case KindPointer, KindNullable:
+			err = checkRef(ts, ts.Elem, "element")
+			codec = &DynamicOptionalCodec{registry: r, elemTag: ts.Elem}
+		case KindInterface:
+			impls := make(map[byte]bool, len(ts.Impls))
+			for _, impl := range ts.Impls {
+				if err = checkRef(ts, impl, "implementation"); err != nil {
+					break
+				}
+				impls[impl] = true
+			}
+			codec = &DynamicInterfaceCodec{registry: r, name: ts.Name, impls: impls}
+		case KindAny:
+			codec = &InterfaceCodec{registry: r}
+		case KindMapStringAny:
+			codec = &MapStringAnyCodec{registry: r}
+		case KindBinaryMarshaler, KindGobEncoder, KindCustom:
+			codec = &RawCodec{}
+		case KindTextMarshaler:
+			codec = &RawCodec{text: true}
+		case KindJSONMarshaler:
+			codec = &RawCodec{json: true}
+		default:
+			return nil, fmt.Errorf("schema tag %d (%s): unknown kind %q", ts.Tag, ts.Name, ts.Kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Dynamic codecs have no Go type, so only the tag is registered.
+		r.codecs[ts.Tag] = codec
+		if ts.Tag >= r.nextStructTag {
+			r.nextStructTag = ts.Tag + 1
+		}
+	}
+
+	return r, nil
+}
+
+// onDemandPrimitive returns the codec for a built-in type that resolveType registers
+// on a tag of its own, such as time.Location held by value. Primitives it does not
+// know, for example a type registered with a built-in codec under a custom name,
+// decode to their raw payload.
+func onDemandPrimitive(name string) Codec {
+	switch name {
+	case "time.Location":
+		return &LocationCodec{byValue: true}
+	}
+	return &RawCodec{}
+}
+
+// encodeByTag encodes value with the codec registered for tag.
+func (r *CodecRegistry) encodeByTag(tag byte, value interface{}) ([]byte, error) {
+	codec, err := r.GetCodec(tag)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Encode(value)
+}
+
+// decodeByTag decodes data with the codec registered for tag.
+func (r *CodecRegistry) decodeByTag(tag byte, data []byte) (interface{}, error) {
+	codec, err := r.GetCodec(tag)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(data)
+}
+
+// appendElement appends data prefixed with its uint32 length, the element layout
+// shared by SliceCodec, ArrayCodec and MapCodec.
+func appendElement(buf []byte, data []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(buf, uint32(len(data))), data...)
+}
+
+// readElement reads a uint32 length-prefixed element.
+func readElement(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(reader.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DynamicStructCodec handles a struct described by a schema as map[string]interface{}.
+// It uses the StructCodec layout.
+type DynamicStructCodec struct {
+	registry *CodecRegistry
+	name     string
+	fields   []FieldSchema
+}
+
+func (c *DynamicStructCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("value for %s is not map[string]interface{}, got %T", c.name, value)
+	}
+	var buffer bytes.Buffer
+	for _, field := range c.fields {
+		fieldValue, ok := m[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("missing field %s for %s", field.Name, c.name)
+		}
+		encodedValue, err := c.registry.encodeByTag(field.Tag, fieldValue)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding field %s: %w", field.Name, err)
+		}
+		buffer.WriteByte(field.Tag)
+		if err := writeLength(&buffer, len(encodedValue)); err != nil {
+			return nil, fmt.Errorf("error encoding field %s: %w", field.Name, err)
+		}
+		buffer.Write(encodedValue)
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c *DynamicStructCodec) Decode(data []byte) (interface{}, error) {
+	result := make(map[string]interface{}, len(c.fields))
+	reader := bytes.NewReader(data)
+	for _, field := range c.fields {
+		tag, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read field tag for %s: %w", field.Name, err)
+		}
+		if tag != field.Tag {
+			return nil, fmt.Errorf("type mismatch for field %s: expected tag %d, got %d", field.Name, field.Tag, tag)
+		}
+		length, err := readLength(reader, c.registry.opts, reader.Len())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read length for %s: %w", field.Name, err)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil, fmt.Errorf("failed to read payload for %s: %w", field.Name, err)
+		}
+		result[field.Name], err = c.registry.decodeByTag(tag, payload)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding field %s: %w", field.Name, err)
+		}
+	}
+	if err := c.registry.opts.checkTrailing(reader.Len(), c.name); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DynamicSliceCodec handles slices (arrayLen < 0) and arrays described by a schema
+// as []interface{}. It uses the SliceCodec and ArrayCodec layouts.
+type DynamicSliceCodec struct {
+	registry *CodecRegistry
+	elemTag  byte
+	arrayLen int
+}
+
+func (c *DynamicSliceCodec) Encode(value interface{}) ([]byte, error) {
+	elems, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("value is not []interface{}, got %T", value)
+	}
+	var buf []byte
+	if c.arrayLen < 0 {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(elems)))
+	} else if len(elems) != c.arrayLen {
+		return nil, fmt.Errorf("array length mismatch: expected %d, got %d", c.arrayLen, len(elems))
+	}
+	for i, elem := range elems {
+		elemData, err := c.registry.encodeByTag(c.elemTag, elem)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding element %d: %w", i, err)
+		}
+		buf = appendElement(buf, elemData)
+	}
+	return buf, nil
+}
+
+func (c *DynamicSliceCodec) Decode(data []byte) (interface{}, error) {
+	reader := bytes.NewReader(data)
+	count := c.arrayLen
+	if count < 0 {
+		var n uint32
+		if err := binary.Read(reader, binary.BigEndian, &n); err != nil {
+			return nil, fmt.Errorf("failed to read slice count: %w", err)
+		}
+		// Every element has at least a 4-byte length
+		if int64(n) > int64(reader.Len()/4) {
+			return nil, fmt.Errorf("invalid slice count %d for %d bytes of data", n, reader.Len())
+		}
+		count = int(n)
+	}
+
+	result := make([]interface{}, count)
+	for i := range result {
+		elemData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read element %d: %w", i, err)
+		}
+		if result[i], err = c.registry.decodeByTag(c.elemTag, elemData); err != nil {
+			return nil, fmt.Errorf("failed to decode element %d: %w", i, err)
+		}
+	}
+	if err := c.registry.opts.checkTrailing(reader.Len(), "slice"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DynamicMapCodec handles maps described by a schema. Maps with string keys decode to
+// map[string]interface{}, all others to map[interface{}]interface{}. It uses the
+// MapCodec layout.
+type DynamicMapCodec struct {
+	registry *CodecRegistry
+	keyTag   byte
+	valTag   byte
+}
+
+func (c *DynamicMapCodec) Encode(value interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("DynamicMapCodec expects a map, got %T", value)
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(rv.Len()))
+	for _, keyVal := range rv.MapKeys() {
+		key := keyVal.Interface()
+		keyData, err := c.registry.encodeByTag(c.keyTag, key)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding map key %v: %w", key, err)
+		}
+		valData, err := c.registry.encodeByTag(c.valTag, rv.MapIndex(keyVal).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("error encoding map value for key %v: %w", key, err)
+		}
+		buf = appendElement(appendElement(buf, keyData), valData)
+	}
+	return buf, nil
+}
+
+func (c *DynamicMapCodec) Decode(data []byte) (interface{}, error) {
+	reader := bytes.NewReader(data)
+	var count uint32
+	if err := binary.Read(reader, binary.BigEndian, &count); err != nil {
+		return nil, fmt.Errorf("failed to read map count: %w", err)
+	}
+	// Every entry has at least two 4-byte lengths
+	if int64(count) > int64(reader.Len()/8) {
+		return nil, fmt.Errorf("invalid map count %d for %d bytes of data", count, reader.Len())
+	}
+
+	stringKeys := make(map[string]interface{}, count)
+	anyKeys := make(map[interface{}]interface{}, count)
+	for i := 0; i < int(count); i++ {
+		keyData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read map entry %d key: %w", i, err)
+		}
+		key, err := c.registry.decodeByTag(c.keyTag, keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode map entry %d key: %w", i, err)
+		}
+		valData, err := readElement(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read map entry %d value: %w", i, err)
+		}
+		val, err := c.registry.decodeByTag(c.valTag, valData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode map entry %d value: %w", i, err)
+		}
+
+		if key != nil && !reflect.TypeOf(key).Comparable() {
+			return nil, fmt.Errorf("map entry %d key of type %T cannot be used as a generic map key", i, key)
+		}
+		if s, ok := key.(string); ok {
+			stringKeys[s] = val
+		}
+		anyKeys[key] = val
+	}
+	if err := c.registry.opts.checkTrailing(reader.Len(), "map"); err != nil {
+		return nil, err
+	}
+	if len(stringKeys) == len(anyKeys) {
+		return stringKeys, nil
+	}
+	return anyKeys, nil
+}
+
+// DynamicSetCodec handles sets described by a schema as []interface{} of keys.
+// It uses the SetCodec layout.
+type DynamicSetCodec struct {
+	registry *CodecRegistry
+	keyTag   byte
+}
+
+func (c *DynamicSetCodec) Encode(value interface{}) ([]byte, error) {
+	keys, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("value is not []interface{}, got %T", value)
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(keys)))
+	for _, key := range keys {
+		keyData, err := c.registry.encodeByTag(c.keyTag, key)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding set key %v: %w", key, err)
+		}
+		buf = append(binary.AppendUvarint(buf, uint64(len(keyData))), keyData...)
+	}
+	return buf, nil
+}
+
+func (c *DynamicSetCodec) Decode(data []byte) (interface{}, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)-n) {
+		return nil, fmt.Errorf("invalid set count")
+	}
+	data = data[n:]
+	keys := make([]interface{}, count)
+	for i := range keys {
+		keyLen, n := binary.Uvarint(data)
+		if n <= 0 || keyLen > uint64(len(data)-n) {
+			return nil, fmt.Errorf("invalid set key %d length", i)
+		}
+		key, err := c.registry.decodeByTag(c.keyTag, data[n:n+int(keyLen)])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode set key %d: %w", i, err)
+		}
+		keys[i] = key
+		data = data[n+int(keyLen):]
+	}
+	if err := c.registry.opts.checkTrailing(len(data), "set"); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DynamicOptionalCodec handles pointers and nullable values described by a schema.
+// A present value decodes to the element itself and an absent one to nil.
+// It uses the PointerCodec and NullableCodec layout.
+type DynamicOptionalCodec struct {
+	registry *CodecRegistry
+	elemTag  byte
+}
+
+func (c *DynamicOptionalCodec) Encode(value interface{}) ([]byte, error) {
+	if value == nil {
+		return []byte{0}, nil
+	}
+	data, err := c.registry.encodeByTag(c.elemTag, value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{1}, data...), nil
+}
+
+func (c *DynamicOptionalCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid optional data: empty")
+	}
+	if err := c.registry.opts.checkPresence(data[0], "optional"); err != nil {
+		return nil, err
+	}
+	if data[0] == 0 {
+		return nil, nil
+	}
+	return c.registry.decodeByTag(c.elemTag, data[1:])
+}
+
+// DynamicInterfaceCodec handles non-empty interface fields described by a schema.
+// Values decode to whatever their concrete tag decodes to. Since generic values do
+// not carry that tag, encoding is not supported.
+type DynamicInterfaceCodec struct {
+	registry *CodecRegistry
+	name     string
+	impls    map[byte]bool
+}
+
+func (c *DynamicInterfaceCodec) Encode(value interface{}) ([]byte, error) {
+	if value == nil {
+		return []byte{}, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as %s without its Go type", value, c.name)
+}
+
+func (c *DynamicInterfaceCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if !c.impls[data[0]] {
+		return nil, fmt.Errorf("tag %d is not a registered implementation of %s", data[0], c.name)
+	}
+	return c.registry.decodeByTag(data[0], data[1:])
+}
+
+// RawCodec passes payloads through unchanged. It stands in for marshaler-based and
+// custom codecs whose Go types are not available: the payload decodes to []byte, or
+// to a string for text marshalers and json.RawMessage for JSON marshalers.
+type RawCodec struct {
+	text bool
+	json bool
+}
+
+func (c *RawCodec) Encode(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case json.RawMessage:
+		return v, nil
+	}
+	return nil, fmt.Errorf("value %v is not []byte, string or json.RawMessage", value)
+}
+
+func (c *RawCodec) Decode(data []byte) (interface{}, error) {
+	switch {
+	case c.text:
+		return string(data), nil
+	case c.json:
+		return json.RawMessage(append([]byte(nil), data...)), nil
+	}
+	return append([]byte(nil), data...), nil
+}
@@ -0,0 +1,82 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type zoned struct {
+	Name string
+	Zone time.Location
+}
+
+type label string
+
+func TestRegistryFromSchemaOnDemandPrimitives(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+
+	tests := []struct {
+		name     string
+		register func(r *CodecRegistry) error
+		tag      byte
+		value    interface{}
+		want     interface{}
+	}{
+		{
+			name:     "time.Location by value",
+			register: func(r *CodecRegistry) error { _, err := r.RegisterStruct(zoned{}); return err },
+			value:    zoned{Name: "office", Zone: *berlin},
+			want:     map[string]interface{}{"Name": "office", "Zone": "Europe/Berlin"},
+		},
+		{
+			name: "built-in codec under a custom name",
+			register: func(r *CodecRegistry) error {
+				r.RegisterCodec(210, &StringCodec{}, label(""))
+				return nil
+			},
+			tag:   210,
+			value: "draft",
+			want:  []byte("draft"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.RegisterPrimitives()
+			if err := tt.register(registry); err != nil {
+				t.Fatal(err)
+			}
+			encoder := NewEncoder(registry)
+			frame, err := encoder.Encode(tt.value)
+			if tt.tag != 0 {
+				frame, err = encoder.EncodeAs(tt.tag, tt.value)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dynamic, err := NewRegistryFromSchema(registry.Schema())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewDecoder(dynamic, bytes.NewReader(frame)).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Locations are compared by name.
+			if m, ok := got.(map[string]interface{}); ok {
+				if loc, ok := m["Zone"].(time.Location); ok {
+					m["Zone"] = loc.String()
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestDecodeBaselineDynamicValues decodes map[string]interface{} frames written by
+// the original InterfaceCodec and MapStringAnyCodec, so that old logs stay readable.
+func TestDecodeBaselineDynamicValues(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  interface{}
+		exact bool // Map order is random, so only single-entry maps encode identically
+	}{
+		{"ab130200130000000100046e616d6500070200046372796fcd", map[string]interface{}{"name": "cryo"}, true},
+		{"ab130200100000000100016e0007010004fffffff9cd", map[string]interface{}{"n": int32(-7)}, true},
+		{
+			"ab1302002f0000000100066e6573746564002113001e00000002000166000b0300083ff800000000000000026f6b000405000101cd",
+			map[string]interface{}{"nested": map[string]interface{}{"f": 1.5, "ok": true}},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		frame, err := hex.DecodeString(tt.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry := NewCodecRegistry()
+		registry.RegisterPrimitives()
+		got, err := NewDecoder(registry, bytes.NewReader(frame)).Decode()
+		if err != nil {
+			t.Fatalf("decode %s: %v", tt.frame, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %#v, want %#v", got, tt.want)
+		}
+
+		// Values written today must decode the same way.
+		data, err := NewEncoder(registry).Encode(tt.want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.exact && !bytes.Equal(data, frame) {
+			t.Errorf("encoded %x, want %s", data, tt.frame)
+		}
+	}
+}
+
+func TestDynamicValueLengthLimit(t *testing.T) {
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	encoder := NewEncoder(registry)
+
+	fits := map[string]interface{}{"s": strings.Repeat("x", 65535-3)}
+	data, err := encoder.Encode(fits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewDecoder(registry, bytes.NewReader(data)).Decode()
+	if err != nil || !reflect.DeepEqual(got, fits) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	for _, value := range []interface{}{
+		map[string]interface{}{"s": strings.Repeat("x", 65535)},
+		map[string]interface{}{strings.Repeat("k", 65536): int32(1)},
+	} {
+		if _, err := encoder.Encode(value); err == nil {
+			t.Error("expected an error for an element over 64 KiB")
+		}
+	}
+}
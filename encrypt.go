@@ -0,0 +1,154 @@
+package CryoDecoder
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Keyring holds AES-GCM keys by ID for authenticated encryption of frame payloads.
+// The encoder seals every frame with the current key and records its ID in the frame,
+// so keys can be rotated by adding a new key, making it current once every peer has
+// it, and removing the old one after in-flight frames have drained. A Keyring is safe
+// for concurrent use and may be shared by encoders and decoders.
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       map[byte]cipher.AEAD
+	current    byte
+	hasCurrent bool
+}
+
+// NewKeyring returns an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[byte]cipher.AEAD)}
+}
+
+// Add adds an AES-128, AES-192 or AES-256 key (16, 24 or 32 bytes) under id,
+// replacing any key with the same ID. The first key added becomes current.
+func (k *Keyring) Add(id byte, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("invalid key %d: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("invalid key %d: %w", id, err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	if !k.hasCurrent {
+		k.current, k.hasCurrent = id, true
+	}
+	return nil
+}
+
+// SetCurrent selects the key that new frames are sealed with.
+func (k *Keyring) SetCurrent(id byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("unknown key %d", id)
+	}
+	k.current, k.hasCurrent = id, true
+	return nil
+}
+
+// Remove removes the key with the given ID. Removing the current key leaves the
+// keyring unable to seal frames until another key is made current.
+func (k *Keyring) Remove(id byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, id)
+	if k.hasCurrent && k.current == id {
+		k.hasCurrent = false
+	}
+}
+
+// TamperedFrameError is returned by Decoder for a frame that fails authentication:
+// its payload or header was modified, it was sealed with a different key, or it was
+// truncated.
+type TamperedFrameError struct {
+	Tag    byte
+	KeyID  byte
+	Reason string
+}
+
+func (e *TamperedFrameError) Error() string {
+	return fmt.Sprintf("tampered frame (tag %d, key %d): %s", e.Tag, e.KeyID, e.Reason)
+}
+
+// sealedLength returns the size of a sealed payload for n bytes of plaintext.
+func sealedLength(aead cipher.AEAD, n int) int {
+	return 1 + aead.NonceSize() + n + aead.Overhead()
+}
+
+// frameAD is the associated data of a sealed frame: the versioned frame header, the
+// tag and the sealed payload length, so none of them can be changed undetected.
+func frameAD(flags FrameFlags, tag byte, length int) []byte {
+	ad := []byte{BOFHeader, FrameVersion, byte(flags), tag}
+	return binary.BigEndian.AppendUint32(ad, uint32(length))
+}
+
+// seal encrypts payload with the current key. The result is the key ID, a random
+// nonce and the ciphertext with its authentication tag.
+func (k *Keyring) seal(flags FrameFlags, tag byte, payload []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, ok := k.current, k.hasCurrent
+	aead := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("keyring has no current key")
+	}
+
+	out := make([]byte, 1+aead.NonceSize(), sealedLength(aead, len(payload)))
+	out[0] = id
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	nonce := out[1:]
+	return aead.Seal(out, nonce, payload, frameAD(flags, tag, cap(out))), nil
+}
+
+// open is the inverse of seal.
+func (k *Keyring) open(flags FrameFlags, tag byte, sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, &TamperedFrameError{Tag: tag, Reason: "missing key ID"}
+	}
+	id := sealed[0]
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("frame with tag %d is sealed with unknown key %d", tag, id)
+	}
+
+	if len(sealed) < sealedLength(aead, 0) {
+		return nil, &TamperedFrameError{Tag: tag, KeyID: id, Reason: "payload too short"}
+	}
+	nonce := sealed[1 : 1+aead.NonceSize()]
+	payload, err := aead.Open(nil, nonce, sealed[1+aead.NonceSize():], frameAD(flags, tag, len(sealed)))
+	if err != nil {
+		return nil, &TamperedFrameError{Tag: tag, KeyID: id, Reason: "authentication failed"}
+	}
+	return payload, nil
+}
+
+// SetKeyring makes the encoder seal every payload with the keyring's current key
+// (AES-GCM). Sealed frames use the versioned frame header with FlagEncrypted set.
+// Payloads are compressed, if enabled, before they are sealed. A nil k disables
+// encryption.
+func (e *Encoder) SetKeyring(k *Keyring) {
+	e.keyring = k
+}
+
+// SetKeyring lets the decoder open sealed frames with the keys in k. Once a keyring
+// is set, unencrypted frames are rejected; without one, sealed frames are. Frames
+// that fail authentication are reported as *TamperedFrameError.
+func (d *Decoder) SetKeyring(k *Keyring) {
+	d.keyring = k
+}
@@ -0,0 +1,136 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// keyringWith returns a keyring holding the given keys, with current selected.
+func keyringWith(t *testing.T, current byte, keys map[byte][]byte) *Keyring {
+	t.Helper()
+	k := NewKeyring()
+	for id, key := range keys {
+		if err := k.Add(id, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) > 0 {
+		if err := k.SetCurrent(current); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
+
+// pipeDecode writes frame to one end of a pipe and decodes it from the other.
+func pipeDecode(decoder func(conn net.Conn) *Decoder, frame []byte) (interface{}, error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go a.Write(frame)
+	return decoder(b).Decode()
+}
+
+func TestEncryptedFrames(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 16)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	other := bytes.Repeat([]byte{3}, 16)
+
+	tests := []struct {
+		name         string
+		sender       *Keyring // nil sends unencrypted frames
+		receiver     *Keyring // nil decodes without a keyring
+		tamper       func(frame []byte)
+		wantErr      bool
+		wantTampered bool
+	}{
+		{name: "shared key", sender: keyringWith(t, 1, map[byte][]byte{1: key1}), receiver: keyringWith(t, 1, map[byte][]byte{1: key1})},
+		{
+			name:     "rotated key",
+			sender:   keyringWith(t, 2, map[byte][]byte{1: key1, 2: key2}),
+			receiver: keyringWith(t, 1, map[byte][]byte{1: key1, 2: key2}),
+		},
+		{
+			name:     "old key still accepted during rotation",
+			sender:   keyringWith(t, 1, map[byte][]byte{1: key1}),
+			receiver: keyringWith(t, 2, map[byte][]byte{1: key1, 2: key2}),
+		},
+		{
+			name:     "unknown key ID",
+			sender:   keyringWith(t, 2, map[byte][]byte{2: key2}),
+			receiver: keyringWith(t, 1, map[byte][]byte{1: key1}),
+			wantErr:  true,
+		},
+		{
+			name:         "wrong key under the same ID",
+			sender:       keyringWith(t, 1, map[byte][]byte{1: other}),
+			receiver:     keyringWith(t, 1, map[byte][]byte{1: key1}),
+			wantErr:      true,
+			wantTampered: true,
+		},
+		{
+			name:         "modified ciphertext",
+			sender:       keyringWith(t, 1, map[byte][]byte{1: key1}),
+			receiver:     keyringWith(t, 1, map[byte][]byte{1: key1}),
+			tamper:       func(frame []byte) { frame[len(frame)-3] ^= 1 },
+			wantErr:      true,
+			wantTampered: true,
+		},
+		{
+			name:         "modified tag",
+			sender:       keyringWith(t, 1, map[byte][]byte{1: key1}),
+			receiver:     keyringWith(t, 1, map[byte][]byte{1: key1}),
+			tamper:       func(frame []byte) { frame[3] = 1 },
+			wantErr:      true,
+			wantTampered: true,
+		},
+		{name: "unencrypted frame", receiver: keyringWith(t, 1, map[byte][]byte{1: key1}), wantErr: true},
+		{name: "sealed frame without a keyring", sender: keyringWith(t, 1, map[byte][]byte{1: key1}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.RegisterPrimitives()
+			encoder := NewEncoder(registry)
+			if tt.sender != nil {
+				encoder.SetKeyring(tt.sender)
+			}
+			frame, err := encoder.Encode("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.sender != nil && bytes.Contains(frame, []byte("secret")) {
+				t.Fatal("sealed frame contains the plaintext")
+			}
+			if tt.tamper != nil {
+				tt.tamper(frame)
+			}
+
+			got, err := pipeDecode(func(conn net.Conn) *Decoder {
+				decoder := NewDecoder(registry, conn)
+				if tt.receiver != nil {
+					decoder.SetKeyring(tt.receiver)
+				}
+				return decoder
+			}, frame)
+			if tt.wantErr {
+				var tampered *TamperedFrameError
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				if errors.As(err, &tampered) != tt.wantTampered {
+					t.Fatalf("got %v, want a TamperedFrameError: %v", err, tt.wantTampered)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "secret" {
+				t.Errorf("got %v, want secret", got)
+			}
+		})
+	}
+}
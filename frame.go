@@ -0,0 +1,42 @@
+package CryoDecoder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FrameVersion is the newest versioned frame format this package writes and reads.
+const FrameVersion byte = 1
+
+// FrameFlags is the bitfield carried in the header of a versioned frame. A decoder
+// rejects frames with flags it does not support rather than misreading the payload.
+type FrameFlags byte
+
+const (
+	FlagCompressed  FrameFlags = 1 << iota // payload is compressed
+	FlagChecksummed                        // a checksum or MAC follows the payload
+	FlagEncrypted                          // payload is encrypted
+	FlagExtendedTag                        // the tag is wider than one byte
+)
+
+// supportedFrameFlags lists the flags Decoder knows how to handle.
+const supportedFrameFlags = FlagCompressed | FlagChecksummed | FlagEncrypted
+
+var frameFlagNames = []string{"compressed", "checksummed", "encrypted", "extended-tag"}
+
+func (f FrameFlags) String() string {
+	if f == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range frameFlagNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+			f &^= 1 << i
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%02X", byte(f)))
+	}
+	return strings.Join(names, "|")
+}
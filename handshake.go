@@ -0,0 +1,322 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the handshake protocol version spoken by this package.
+// MinProtocolVersion is the oldest peer version Handshake accepts.
+const (
+	ProtocolVersion    uint16 = 1
+	MinProtocolVersion uint16 = 1
+)
+
+// DefaultHandshakeTimeout bounds how long Handshake waits for the peer.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// Hello is the first frame each side sends during Handshake (tag 44). Nonce is
+// random; the two nonces together make up the session ID.
+type Hello struct {
+	Version     uint16
+	Fingerprint Fingerprint
+	Nonce       [16]byte
+}
+
+// helloSize is the length of an encoded Hello.
+const helloSize = 2 + len(Fingerprint{}) + 16
+
+// HelloCodec handles Hello as a 2-byte version followed by the 32-byte fingerprint and
+// the 16-byte nonce.
+type HelloCodec struct{}
+
+func (c *HelloCodec) Encode(value interface{}) ([]byte, error) {
+	h, ok := value.(Hello)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not Hello", value)
+	}
+	buf := append(binary.BigEndian.AppendUint16(nil, h.Version), h.Fingerprint[:]...)
+	return append(buf, h.Nonce[:]...), nil
+}
+
+func (c *HelloCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) != helloSize {
+		return nil, fmt.Errorf("invalid data length for Hello: expected %d, got %d", helloSize, len(data))
+	}
+	return Hello{
+		Version:     binary.BigEndian.Uint16(data),
+		Fingerprint: Fingerprint(data[2:34]),
+		Nonce:       [16]byte(data[34:]),
+	}, nil
+}
+
+// HandshakeResult describes what two peers agreed on.
+type HandshakeResult struct {
+	// Version is the protocol version both sides speak: the lower of the two.
+	Version uint16
+	// PeerFingerprint is the fingerprint of the peer's schema.
+	PeerFingerprint Fingerprint
+	// PeerSchema is the peer's full schema. It is only exchanged, and only set, when
+	// the fingerprints differ.
+	PeerSchema *Schema
+	// Tags lists, in order, the tags both sides define compatibly. Only these tags
+	// can be sent safely.
+	Tags []byte
+	// Changed lists the non-breaking differences on tags in Tags, such as renamed
+	// types and fields or interface implementations only this side has, described as
+	// changes from the peer's schema to the local one. Like PeerSchema, it is only set
+	// when the fingerprints differ.
+	Changed []SchemaChange
+	// SessionID is derived from a random nonce contributed by each side, so it is the
+	// same on both ends and different for every connection. Pass it to
+	// Encoder.SetSession and Decoder.SetSession so that signed frames cannot be
+	// replayed on another connection.
+	SessionID []byte
+}
+
+// Supports reports whether tag is one both sides agreed on.
+func (h *HandshakeResult) Supports(tag byte) bool {
+	i := sort.Search(len(h.Tags), func(i int) bool { return h.Tags[i] >= tag })
+	return i < len(h.Tags) && h.Tags[i] == tag
+}
+
+// Handshake agrees on a protocol version and schema with the peer on rw before any
+// other frames are exchanged. Both sides send a Hello with their version and schema
+// fingerprint; if the fingerprints differ they also send their full schemas. The
+// handshake fails if the peer's version is not supported or if a tag is defined
+// differently on the two sides, so mismatched deployments fail at connect time.
+// Tags that only one side knows are left out of the result rather than failing.
+// Both peers must call Handshake; the registry needs its primitives registered.
+// Handshake gives up after DefaultHandshakeTimeout; use HandshakeContext to choose
+// the deadline.
+func Handshake(rw io.ReadWriter, r *CodecRegistry) (*HandshakeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultHandshakeTimeout)
+	defer cancel()
+	return HandshakeContext(ctx, rw, r)
+}
+
+// HandshakeContext is Handshake with a context. If rw has a SetDeadline method, as
+// net.Conn does, the context's deadline and cancellation interrupt blocked reads and
+// writes, and the deadline is cleared again afterwards. Other transports cannot be
+// interrupted: the handshake returns when ctx is done, but a read or write may still
+// be pending, so close rw after a failed handshake.
+func HandshakeContext(ctx context.Context, rw io.ReadWriter, r *CodecRegistry) (*HandshakeResult, error) {
+	local := r.Schema()
+	hello := Hello{Version: ProtocolVersion, Fingerprint: local.Fingerprint()}
+	if _, err := rand.Read(hello.Nonce[:]); err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	value, err := exchangeFrame(ctx, rw, r, hello)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	peer, ok := value.(Hello)
+	if !ok {
+		return nil, fmt.Errorf("handshake failed: expected Hello, got %T", value)
+	}
+	if peer.Version < MinProtocolVersion {
+		return nil, fmt.Errorf("handshake failed: peer protocol version %d is older than the minimum %d", peer.Version, MinProtocolVersion)
+	}
+
+	result := &HandshakeResult{
+		Version:         min(peer.Version, ProtocolVersion),
+		PeerFingerprint: peer.Fingerprint,
+		SessionID:       sessionID(hello.Nonce, peer.Nonce),
+	}
+	if peer.Fingerprint == hello.Fingerprint {
+		for _, ts := range local.Types {
+			result.Tags = append(result.Tags, ts.Tag)
+		}
+		return result, nil
+	}
+
+	value, err = exchangeFrame(ctx, rw, r, local)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	peerSchema, ok := value.(Schema)
+	if !ok {
+		return nil, fmt.Errorf("handshake failed: expected Schema, got %T", value)
+	}
+	result.PeerSchema = &peerSchema
+
+	tags, changed, conflicts := agreeOnTags(local, peerSchema)
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("handshake failed: schema fingerprint %.12s does not match peer %.12s; %d tag(s) defined differently:\n  %s",
+			hello.Fingerprint, peer.Fingerprint, len(conflicts), strings.Join(conflicts, "\n  "))
+	}
+	result.Tags = tags
+	result.Changed = changed
+	return result, nil
+}
+
+// sessionID hashes the two nonces in a fixed order, so both sides get the same ID.
+func sessionID(a, b [16]byte) []byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte("CryoDecoder session"))
+	h.Write(a[:])
+	h.Write(b[:])
+	return h.Sum(nil)
+}
+
+// deadlineSetter is implemented by net.Conn and the other transports whose blocked
+// reads and writes can be interrupted.
+type deadlineSetter interface {
+	SetDeadline(t time.Time) error
+}
+
+// exchangeFrame sends value while reading the peer's frame, so that both sides can
+// send first over unbuffered transports such as net.Pipe. It returns ctx.Err() once
+// ctx is done.
+func exchangeFrame(ctx context.Context, rw io.ReadWriter, r *CodecRegistry, value interface{}) (interface{}, error) {
+	frame, err := NewEncoder(r).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if conn, ok := rw.(deadlineSetter); ok {
+		deadline, _ := ctx.Deadline()
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("failed to set deadline: %w", err)
+		}
+		// A deadline in the past wakes up blocked calls when ctx is cancelled early.
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			conn.SetDeadline(time.Unix(1, 0))
+			close(interrupted)
+		})
+		defer func() {
+			if !stop() {
+				<-interrupted
+			}
+			conn.SetDeadline(time.Time{})
+		}()
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(frame)
+		written <- err
+	}()
+	type readResult struct {
+		value interface{}
+		err   error
+	}
+	read := make(chan readResult, 1)
+	go func() {
+		value, err := NewDecoder(r, rw).Decode()
+		read <- readResult{value, err}
+	}()
+
+	var received interface{}
+	for pending := 2; pending > 0; pending-- {
+		select {
+		case err := <-written:
+			if err != nil {
+				return nil, fmt.Errorf("failed to send %T: %w", value, contextErr(ctx, err))
+			}
+		case res := <-read:
+			if res.err != nil {
+				return nil, fmt.Errorf("failed to receive peer frame: %w", contextErr(ctx, res.err))
+			}
+			received = res.value
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return received, nil
+}
+
+// contextErr returns ctx.Err() for an I/O error caused by the deadline exchangeFrame
+// set from ctx, and err otherwise.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// agreeOnTags returns the tags both schemas define compatibly and the non-breaking
+// changes on them, along with a description of each breaking difference. A tag whose
+// definition refers to a tag that is not agreed on is not agreed on either.
+func agreeOnTags(local, peer Schema) ([]byte, []SchemaChange, []string) {
+	peerTypes := make(map[byte]TypeSchema, len(peer.Types))
+	for _, ts := range peer.Types {
+		peerTypes[ts.Tag] = ts
+	}
+
+	agreed := make(map[byte]TypeSchema)
+	changes := make(map[byte][]SchemaChange)
+	var conflicts []string
+	for _, ts := range local.Types {
+		other, ok := peerTypes[ts.Tag]
+		if !ok {
+			continue
+		}
+		single := func(t TypeSchema) Schema { return Schema{Types: []TypeSchema{t}} }
+		compat := CheckCompatibility(single(other), single(ts))
+		if !compat.Compatible() {
+			for _, change := range compat.Breaking {
+				conflicts = append(conflicts, change.String())
+			}
+			continue
+		}
+		agreed[ts.Tag] = ts
+		changes[ts.Tag] = compat.NonBreaking
+	}
+
+	// Drop tags that depend on tags only one side has, until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for tag, ts := range agreed {
+			if !referencesAgreed(ts, agreed) {
+				delete(agreed, tag)
+				changed = true
+			}
+		}
+	}
+
+	tags := make([]byte, 0, len(agreed))
+	for tag := range agreed {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	var changed []SchemaChange
+	for _, tag := range tags {
+		changed = append(changed, changes[tag]...)
+	}
+	return tags, changed, conflicts
+}
+
+// referencesAgreed reports whether every tag ts refers to is in agreed.
+func referencesAgreed(ts TypeSchema, agreed map[byte]TypeSchema) bool {
+	refs := append([]byte{ts.Elem, ts.Key, ts.Value}, ts.Impls...)
+	for _, field := range ts.Fields {
+		refs = append(refs, field.Tag)
+	}
+	for _, ref := range refs {
+		if _, ok := agreed[ref]; ref != 0 && !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package CryoDecoder
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+type orderV1 struct {
+	ID    int64
+	Total float64
+}
+
+type orderRenamed struct {
+	ID     int64
+	Amount float64
+}
+
+type orderTrimmed struct {
+	ID int64
+}
+
+type invoice struct {
+	Number string
+}
+
+type document interface{}
+
+// documents lists the implementations to register for document.
+type documents []document
+
+// structRegistry registers the example structs in order, from tag 200 up, and
+// documents as the implementations of the document interface.
+func structRegistry(t *testing.T, examples ...interface{}) *CodecRegistry {
+	t.Helper()
+	registry := NewCodecRegistry()
+	registry.RegisterPrimitives()
+	for _, example := range examples {
+		var err error
+		if impls, ok := example.(documents); ok {
+			_, err = RegisterInterface(registry, impls...)
+		} else {
+			_, err = registry.RegisterStruct(example)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return registry
+}
+
+// handshakePair runs Handshake on both ends of a pipe.
+func handshakePair(local, peer *CodecRegistry) (*HandshakeResult, error, error) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	peerErr := make(chan error, 1)
+	go func() {
+		_, err := Handshake(b, peer)
+		peerErr <- err
+	}()
+	result, err := Handshake(a, local)
+	return result, err, <-peerErr
+}
+
+func TestHandshakeAgreesOnTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		local, peer []interface{}
+		wantErr     bool
+		wantPeerErr bool
+		wantTags    []byte // the struct tags in Tags
+		wantChanged []string
+	}{
+		{name: "identical", local: []interface{}{orderV1{}}, peer: []interface{}{orderV1{}}, wantTags: []byte{200}},
+		{
+			name:     "tag only the peer has",
+			local:    []interface{}{orderV1{}},
+			peer:     []interface{}{orderV1{}, invoice{}},
+			wantTags: []byte{200},
+		},
+		{
+			name:     "renamed struct and field",
+			local:    []interface{}{orderRenamed{}},
+			peer:     []interface{}{orderV1{}, invoice{}},
+			wantTags: []byte{200},
+			wantChanged: []string{
+				"tag 200 (CryoDecoder.orderV1): renamed to CryoDecoder.orderRenamed",
+				"tag 200 (CryoDecoder.orderV1): field Total renamed to Amount",
+			},
+		},
+		{
+			// Adding an implementation is non-breaking for this side only: the peer
+			// sees one removed and fails.
+			name:        "implementation only this side has",
+			local:       []interface{}{orderV1{}, invoice{}, documents{orderV1{}, invoice{}}},
+			peer:        []interface{}{orderV1{}, invoice{}, documents{orderV1{}}},
+			wantPeerErr: true,
+			wantTags:    []byte{200, 201, 202},
+			wantChanged: []string{"tag 202 (CryoDecoder.document): implementation 201 added"},
+		},
+		{name: "removed field", local: []interface{}{orderTrimmed{}}, peer: []interface{}{orderV1{}}, wantErr: true, wantPeerErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err, peerErr := handshakePair(structRegistry(t, tt.local...), structRegistry(t, tt.peer...))
+			if (peerErr != nil) != tt.wantPeerErr {
+				t.Fatalf("got peer error %v, want error %v", peerErr, tt.wantPeerErr)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tags []byte
+			for _, tag := range result.Tags {
+				if tag >= firstStructTag {
+					tags = append(tags, tag)
+				}
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("got struct tags %v, want %v", tags, tt.wantTags)
+			}
+			var changed []string
+			for _, change := range result.Changed {
+				changed = append(changed, change.String())
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("got changes %q, want %q", changed, tt.wantChanged)
+			}
+		})
+	}
+}
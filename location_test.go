@@ -0,0 +1,61 @@
+package CryoDecoder
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLocationCodecEncode(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+	tzdata, err := os.ReadFile("/usr/share/zoneinfo/Europe/Berlin")
+	if err != nil {
+		t.Skip("zoneinfo file unavailable:", err)
+	}
+	custom, err := time.LoadLocationFromTZData("Custom/Berlin", tzdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		loc     *time.Location
+		wantErr bool
+	}{
+		{name: "UTC", loc: time.UTC},
+		{name: "named zone", loc: berlin},
+		{name: "fixed zone", loc: time.FixedZone("UTC+5:30", 5*3600+1800)},
+		{name: "fixed zone reusing a zone name", loc: time.FixedZone("Europe/Berlin", 3600)},
+		{name: "zone with daylight saving time under an unknown name", loc: custom, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := &LocationCodec{}
+			data, err := codec.Encode(tt.loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := codec.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := decoded.(*time.Location)
+			for _, instant := range zoneSamples {
+				gotName, gotOffset := instant.In(got).Zone()
+				wantName, wantOffset := instant.In(tt.loc).Zone()
+				if gotName != wantName || gotOffset != wantOffset {
+					t.Fatalf("at %v: got %s%+d, want %s%+d", instant, gotName, gotOffset, wantName, wantOffset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package CryoDecoder
+
+import (
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// MarshalerKind identifies a marshaling interface that resolveType can fall back to
+// for types it cannot encode natively.
+type MarshalerKind byte
+
+const (
+	BinaryMarshalerKind MarshalerKind = iota // encoding.BinaryMarshaler
+	TextMarshalerKind                        // encoding.TextMarshaler
+	GobEncoderKind                           // gob.GobEncoder
+	JSONMarshalerKind                        // json.Marshaler
+)
+
+func (k MarshalerKind) String() string {
+	switch k {
+	case BinaryMarshalerKind:
+		return "BinaryMarshaler"
+	case TextMarshalerKind:
+		return "TextMarshaler"
+	case GobEncoderKind:
+		return "GobEncoder"
+	case JSONMarshalerKind:
+		return "JSONMarshaler"
+	}
+	return fmt.Sprintf("MarshalerKind(%d)", byte(k))
+}
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textMarshalerType     = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	gobEncoderType        = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	gobDecoderType        = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+	jsonMarshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType   = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// DefaultMarshalerOrder returns the fallback order used by a new CodecRegistry:
+// binary first, as the most compact, and JSON last.
+func DefaultMarshalerOrder() []MarshalerKind {
+	return []MarshalerKind{BinaryMarshalerKind, TextMarshalerKind, GobEncoderKind, JSONMarshalerKind}
+}
+
+// SetMarshalerOrder sets which marshaling interfaces resolveType falls back to, in order
+// of preference. Kinds that are left out are never used. It only affects types resolved
+// after the call.
+func (r *CodecRegistry) SetMarshalerOrder(kinds ...MarshalerKind) {
+	r.marshalerOrder = append([]MarshalerKind(nil), kinds...)
+}
+
+// splitMarshalerOrder splits order into the kinds resolveType tries for a struct before
+// encoding it natively and the kinds it tries when that is not possible. Only
+// BinaryMarshaler, which structs have always been encoded with, comes first.
+func splitMarshalerOrder(order []MarshalerKind) (early, late []MarshalerKind) {
+	for _, kind := range order {
+		if kind == BinaryMarshalerKind {
+			early = append(early, kind)
+		} else {
+			late = append(late, kind)
+		}
+	}
+	return early, late
+}
+
+// marshalerCodec returns a codec for the first of kinds that t or *t implements.
+// It returns nil if t implements none of them. If t implements only one half of an
+// interface pair, the next kind is tried and the error is only reported when no kind
+// succeeds.
+func marshalerCodec(t reflect.Type, kinds []MarshalerKind) (Codec, error) {
+	var firstErr error
+	for _, kind := range kinds {
+		var codec Codec
+		var err error
+		switch kind {
+		case BinaryMarshalerKind:
+			if !implementsEither(t, binaryMarshalerType) && !implementsEither(t, binaryUnmarshalerType) {
+				continue
+			}
+			codec, err = NewMarshalerCodec(t)
+		case TextMarshalerKind:
+			if !implementsEither(t, textMarshalerType) {
+				continue
+			}
+			codec, err = NewTextMarshalerCodec(t)
+		case GobEncoderKind:
+			if !implementsEither(t, gobEncoderType) {
+				continue
+			}
+			codec, err = NewGobEncoderCodec(t)
+		case JSONMarshalerKind:
+			if !implementsEither(t, jsonMarshalerType) {
+				continue
+			}
+			codec, err = NewJSONMarshalerCodec(t)
+		default:
+			return nil, fmt.Errorf("unknown marshaler kind %v", kind)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		return codec, nil
+	}
+	return nil, firstErr
+}
+
+// implementsEither reports whether t or *t implements iface.
+func implementsEither(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+// asMarshaler returns value as M. If only the pointer type implements M, it returns
+// a pointer to a copy of value instead.
+func asMarshaler[M any](value interface{}) (M, bool) {
+	if m, ok := value.(M); ok || value == nil {
+		return m, ok
+	}
+	ptr := reflect.New(reflect.TypeOf(value))
+	ptr.Elem().Set(reflect.ValueOf(value))
+	m, ok := ptr.Interface().(M)
+	return m, ok
+}
+
+// checkUnmarshaler reports an error unless *t implements the unmarshaler interface.
+func checkUnmarshaler(t reflect.Type, unmarshalerType reflect.Type) error {
+	if !reflect.PointerTo(t).Implements(unmarshalerType) {
+		return fmt.Errorf("type %v cannot be decoded: *%v does not implement %v", t, t, unmarshalerType)
+	}
+	return nil
+}
+
+// TextMarshalerCodec handles types implementing encoding.TextMarshaler.
+// The payload is the MarshalText output.
+type TextMarshalerCodec struct {
+	typ reflect.Type
+}
+
+// NewTextMarshalerCodec creates a TextMarshalerCodec for t.
+// It fails unless *t implements encoding.TextUnmarshaler.
+func NewTextMarshalerCodec(t reflect.Type) (*TextMarshalerCodec, error) {
+	if err := checkUnmarshaler(t, textUnmarshalerType); err != nil {
+		return nil, err
+	}
+	return &TextMarshalerCodec{typ: t}, nil
+}
+
+func (c *TextMarshalerCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := asMarshaler[encoding.TextMarshaler](value)
+	if !ok {
+		return nil, fmt.Errorf("type %v does not implement TextMarshaler", c.typ)
+	}
+	return m.MarshalText()
+}
+
+func (c *TextMarshalerCodec) Decode(data []byte) (interface{}, error) {
+	ptr := reflect.New(c.typ)
+	if err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText(data); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// GobEncoderCodec handles types implementing gob.GobEncoder.
+// The payload is the GobEncode output.
+type GobEncoderCodec struct {
+	typ reflect.Type
+}
+
+// NewGobEncoderCodec creates a GobEncoderCodec for t.
+// It fails unless *t implements gob.GobDecoder.
+func NewGobEncoderCodec(t reflect.Type) (*GobEncoderCodec, error) {
+	if err := checkUnmarshaler(t, gobDecoderType); err != nil {
+		return nil, err
+	}
+	return &GobEncoderCodec{typ: t}, nil
+}
+
+func (c *GobEncoderCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := asMarshaler[gob.GobEncoder](value)
+	if !ok {
+		return nil, fmt.Errorf("type %v does not implement GobEncoder", c.typ)
+	}
+	return m.GobEncode()
+}
+
+func (c *GobEncoderCodec) Decode(data []byte) (interface{}, error) {
+	ptr := reflect.New(c.typ)
+	if err := ptr.Interface().(gob.GobDecoder).GobDecode(data); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// JSONMarshalerCodec handles types implementing json.Marshaler.
+// The payload is the MarshalJSON output.
+type JSONMarshalerCodec struct {
+	typ reflect.Type
+}
+
+// NewJSONMarshalerCodec creates a JSONMarshalerCodec for t.
+// It fails unless *t implements json.Unmarshaler.
+func NewJSONMarshalerCodec(t reflect.Type) (*JSONMarshalerCodec, error) {
+	if err := checkUnmarshaler(t, jsonUnmarshalerType); err != nil {
+		return nil, err
+	}
+	return &JSONMarshalerCodec{typ: t}, nil
+}
+
+func (c *JSONMarshalerCodec) Encode(value interface{}) ([]byte, error) {
+	m, ok := asMarshaler[json.Marshaler](value)
+	if !ok {
+		return nil, fmt.Errorf("type %v does not implement json.Marshaler", c.typ)
+	}
+	return m.MarshalJSON()
+}
+
+func (c *JSONMarshalerCodec) Decode(data []byte) (interface{}, error) {
+	ptr := reflect.New(c.typ)
+	if err := ptr.Interface().(json.Unmarshaler).UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
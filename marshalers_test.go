@@ -0,0 +1,102 @@
+package CryoDecoder
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type binaryStruct struct{ A int32 }
+
+func (s binaryStruct) MarshalBinary() ([]byte, error) { return []byte{byte(s.A)}, nil }
+func (s *binaryStruct) UnmarshalBinary(b []byte) error {
+	s.A = int32(b[0])
+	return nil
+}
+
+type binaryChanStruct struct {
+	A int32
+	C chan int
+}
+
+func (s binaryChanStruct) MarshalBinary() ([]byte, error) { return []byte{byte(s.A)}, nil }
+func (s *binaryChanStruct) UnmarshalBinary(b []byte) error {
+	s.A = int32(b[0])
+	return nil
+}
+
+type jsonStruct struct{ A int32 }
+
+func (s jsonStruct) MarshalJSON() ([]byte, error) { return json.Marshal([]int32{s.A}) }
+func (s *jsonStruct) UnmarshalJSON(b []byte) error {
+	var a []int32
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+	s.A = a[0]
+	return nil
+}
+
+type jsonChanStruct struct {
+	A int32
+	C chan int
+}
+
+func (s jsonChanStruct) MarshalJSON() ([]byte, error)  { return json.Marshal(s.A) }
+func (s *jsonChanStruct) UnmarshalJSON(b []byte) error { return json.Unmarshal(b, &s.A) }
+
+type textPrivateStruct struct{ a int32 }
+
+func (s textPrivateStruct) MarshalText() ([]byte, error) { return []byte{byte(s.a)}, nil }
+func (s *textPrivateStruct) UnmarshalText(b []byte) error {
+	s.a = int32(b[0])
+	return nil
+}
+
+type textColor int
+
+func (c textColor) MarshalText() ([]byte, error) { return []byte{byte(c)}, nil }
+func (c *textColor) UnmarshalText(b []byte) error {
+	*c = textColor(b[0])
+	return nil
+}
+
+func TestMarshalerFallbackOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		codec Codec
+	}{
+		{"binary struct", binaryStruct{A: 1}, &MarshalerCodec{}},
+		{"binary struct with unsupported field", binaryChanStruct{A: 2}, &MarshalerCodec{}},
+		{"json struct encodes natively", jsonStruct{A: 3}, &StructCodec{}},
+		{"json struct with unsupported field", jsonChanStruct{A: 4}, &JSONMarshalerCodec{}},
+		{"text struct with unexported field", textPrivateStruct{a: 5}, &TextMarshalerCodec{}},
+		{"named basic type", textColor(6), &TextMarshalerCodec{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.RegisterPrimitives()
+			tag, err := registry.GetTag(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec := registry.codecs[tag]
+			if reflect.TypeOf(codec) != reflect.TypeOf(tt.codec) {
+				t.Fatalf("got %T, want %T", codec, tt.codec)
+			}
+			data, err := codec.Encode(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := codec.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.value) {
+				t.Errorf("got %#v, want %#v", got, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package CryoDecoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// Message is a decoded frame as seen by Mux handlers and middleware.
+type Message struct {
+	Tag   byte
+	Value interface{}
+}
+
+// Handler handles a decoded frame.
+type Handler func(ctx context.Context, msg Message) error
+
+// Middleware wraps a Handler, for example to log, measure or recover from panics.
+type Middleware func(next Handler) Handler
+
+// Mux dispatches decoded frames to handlers registered by message type. Dispatch
+// uses the frame tag, so no type switch is needed.
+type Mux struct {
+	registry   *CodecRegistry
+	handlers   map[byte]Handler
+	middleware []Middleware
+	fallback   Handler
+}
+
+// NewMux returns a Mux that resolves message types with registry. The registry must
+// be the one the Decoder passed to Serve uses.
+func NewMux(registry *CodecRegistry) *Mux {
+	return &Mux{registry: registry, handlers: make(map[byte]Handler)}
+}
+
+// Handle registers fn for messages of type T, registering T with the mux's registry
+// if needed. It fails if another handler already covers T's tag.
+func Handle[T any](m *Mux, fn func(ctx context.Context, msg T) error) error {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	_, tag, err := m.registry.resolveType(t)
+	if err != nil {
+		return fmt.Errorf("cannot register %v: %w", t, err)
+	}
+	if _, exists := m.handlers[tag]; exists {
+		return fmt.Errorf("tag %d (%v) already has a handler", tag, t)
+	}
+	m.handlers[tag] = func(ctx context.Context, msg Message) error {
+		if v, ok := msg.Value.(T); ok {
+			return fn(ctx, v)
+		}
+		// Types sharing a tag may decode to a different, convertible type
+		rv := reflect.ValueOf(msg.Value)
+		if !rv.IsValid() {
+			var zero T
+			return fn(ctx, zero)
+		}
+		if !rv.Type().ConvertibleTo(t) {
+			return fmt.Errorf("cannot convert %T to %v for tag %d", msg.Value, t, msg.Tag)
+		}
+		return fn(ctx, rv.Convert(t).Interface().(T))
+	}
+	return nil
+}
+
+// Use appends middleware. The first middleware added is the outermost.
+func (m *Mux) Use(middleware ...Middleware) {
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// SetFallback sets the handler for frames whose tag has no handler. By default such
+// frames are reported as an error. Middleware applies to the fallback too.
+func (m *Mux) SetFallback(fallback Handler) {
+	m.fallback = fallback
+}
+
+// Dispatch runs the handler for msg.Tag, or the fallback, through the middleware.
+func (m *Mux) Dispatch(ctx context.Context, msg Message) error {
+	return m.wrap(m.route)(ctx, msg)
+}
+
+// Serve reads frames from d and dispatches them in order until d returns an error,
+// a handler returns an error, or ctx is done. ctx is checked between frames; it does
+// not interrupt a blocked read. The end of the stream returns nil.
+func (m *Mux) Serve(ctx context.Context, d *Decoder) error {
+	handler := m.wrap(m.route)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		tag, value, err := d.DecodeTagged()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := handler(ctx, Message{Tag: tag, Value: value}); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Mux) wrap(h Handler) Handler {
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	return h
+}
+
+func (m *Mux) route(ctx context.Context, msg Message) error {
+	if h, ok := m.handlers[msg.Tag]; ok {
+		return h(ctx, msg)
+	}
+	if m.fallback != nil {
+		return m.fallback(ctx, msg)
+	}
+	return fmt.Errorf("no handler for tag %d (%T)", msg.Tag, msg.Value)
+}
+
+// Recover returns middleware that turns a panicking handler into an error that
+// includes the stack trace.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = fmt.Errorf("handler for tag %d panicked: %v\n%s", msg.Tag, p, debug.Stack())
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Logging returns middleware that reports every message, how long its handler took
+// and the handler's error through logf (for example log.Printf).
+func Logging(logf func(format string, args ...interface{})) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			logf("tag %d (%T) handled in %v, err=%v", msg.Tag, msg.Value, time.Since(start), err)
+			return err
+		}
+	}
+}
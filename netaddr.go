@@ -0,0 +1,201 @@
+package CryoDecoder
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// appendAddr appends a netip.Addr in its natural size: nothing for the zero Addr,
+// 4 bytes for IPv4 and 16 bytes for IPv6, followed by the zone name if any.
+func appendAddr(buf []byte, addr netip.Addr) []byte {
+	switch {
+	case !addr.IsValid():
+		return buf
+	case addr.Is4():
+		a := addr.As4()
+		return append(buf, a[:]...)
+	}
+	a := addr.As16()
+	return append(append(buf, a[:]...), addr.Zone()...)
+}
+
+// parseAddr is the inverse of appendAddr. It consumes all of data.
+func parseAddr(data []byte) (netip.Addr, error) {
+	switch {
+	case len(data) == 0:
+		return netip.Addr{}, nil
+	case len(data) == 4:
+		return netip.AddrFrom4([4]byte(data)), nil
+	case len(data) >= 16:
+		addr := netip.AddrFrom16([16]byte(data[:16]))
+		if len(data) > 16 {
+			addr = addr.WithZone(string(data[16:]))
+		}
+		return addr, nil
+	}
+	return netip.Addr{}, fmt.Errorf("invalid data length for netip.Addr: expected 0, 4 or at least 16, got %d", len(data))
+}
+
+// NetipAddrCodec handles netip.Addr as 4 bytes (IPv4) or 16 bytes plus an optional
+// zone (IPv6). The zero Addr is an empty payload.
+type NetipAddrCodec struct{}
+
+func (c *NetipAddrCodec) Encode(value interface{}) ([]byte, error) {
+	addr, ok := value.(netip.Addr)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not netip.Addr", value)
+	}
+	return appendAddr(nil, addr), nil
+}
+
+func (c *NetipAddrCodec) Decode(data []byte) (interface{}, error) {
+	return parseAddr(data)
+}
+
+// NetipAddrPortCodec handles netip.AddrPort as a 2-byte port followed by the address
+// in the NetipAddrCodec layout.
+type NetipAddrPortCodec struct{}
+
+func (c *NetipAddrPortCodec) Encode(value interface{}) ([]byte, error) {
+	ap, ok := value.(netip.AddrPort)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not netip.AddrPort", value)
+	}
+	result := binary.BigEndian.AppendUint16(make([]byte, 0, 18), ap.Port())
+	return appendAddr(result, ap.Addr()), nil
+}
+
+func (c *NetipAddrPortCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("invalid data length for netip.AddrPort: expected at least 2, got %d", len(data))
+	}
+	addr, err := parseAddr(data[2:])
+	if err != nil {
+		return nil, err
+	}
+	return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(data[:2])), nil
+}
+
+// NetipPrefixCodec handles netip.Prefix as a 1-byte prefix length followed by the
+// address in the NetipAddrCodec layout. The zero Prefix is an empty payload.
+type NetipPrefixCodec struct{}
+
+func (c *NetipPrefixCodec) Encode(value interface{}) ([]byte, error) {
+	prefix, ok := value.(netip.Prefix)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not netip.Prefix", value)
+	}
+	if !prefix.IsValid() {
+		return []byte{}, nil
+	}
+	return appendAddr([]byte{byte(prefix.Bits())}, prefix.Addr()), nil
+}
+
+func (c *NetipPrefixCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return netip.Prefix{}, nil
+	}
+	addr, err := parseAddr(data[1:])
+	if err != nil {
+		return nil, err
+	}
+	prefix := netip.PrefixFrom(addr, int(data[0]))
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("invalid netip.Prefix: %v/%d", addr, data[0])
+	}
+	return prefix, nil
+}
+
+// NetIPCodec handles net.IP as its raw 0, 4 or 16 bytes.
+type NetIPCodec struct{}
+
+func (c *NetIPCodec) Encode(value interface{}) ([]byte, error) {
+	ip, ok := value.(net.IP)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not net.IP", value)
+	}
+	if len(ip) != 0 && len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("invalid net.IP length %d", len(ip))
+	}
+	return []byte(ip), nil
+}
+
+func (c *NetIPCodec) Decode(data []byte) (interface{}, error) {
+	switch len(data) {
+	case 0:
+		return net.IP(nil), nil
+	case net.IPv4len, net.IPv6len:
+		return net.IP(append([]byte(nil), data...)), nil
+	}
+	return nil, fmt.Errorf("invalid data length for net.IP: expected 0, 4 or 16, got %d", len(data))
+}
+
+// NetHardwareAddrCodec handles net.HardwareAddr as its raw bytes.
+type NetHardwareAddrCodec struct{}
+
+func (c *NetHardwareAddrCodec) Encode(value interface{}) ([]byte, error) {
+	mac, ok := value.(net.HardwareAddr)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not net.HardwareAddr", value)
+	}
+	return []byte(mac), nil
+}
+
+func (c *NetHardwareAddrCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return net.HardwareAddr(nil), nil
+	}
+	return net.HardwareAddr(append([]byte(nil), data...)), nil
+}
+
+// UUID is a 16-byte universally unique identifier (RFC 9562).
+type UUID [16]byte
+
+// ParseUUID parses the canonical form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36]
+	if _, err := hex.Decode(u[:], []byte(digits)); err != nil {
+		return u, fmt.Errorf("invalid UUID %q: %w", s, err)
+	}
+	return u, nil
+}
+
+// String returns the canonical form of u.
+func (u UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:36], u[10:16])
+	return string(buf[:])
+}
+
+// UUIDCodec handles UUID as its raw 16 bytes.
+type UUIDCodec struct{}
+
+func (c *UUIDCodec) Encode(value interface{}) ([]byte, error) {
+	u, ok := value.(UUID)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not UUID", value)
+	}
+	return u[:], nil
+}
+
+func (c *UUIDCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) != 16 {
+		return nil, fmt.Errorf("invalid data length for UUID: expected 16, got %d", len(data))
+	}
+	return UUID(data), nil
+}
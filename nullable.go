@@ -0,0 +1,147 @@
+package CryoDecoder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Optional holds a value of type T that may be absent. Unlike a *T field it needs
+// no heap allocation; on the wire it costs a single presence byte.
+type Optional[T any] struct {
+	Value T
+	Valid bool
+}
+
+// Some returns an Optional holding v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{Value: v, Valid: true}
+}
+
+// None returns an empty Optional.
+func None[T any]() Optional[T] {
+	return Optional[T]{}
+}
+
+// Get returns the value and whether it is present.
+func (o Optional[T]) Get() (T, bool) {
+	return o.Value, o.Valid
+}
+
+// OrElse returns the value if present and fallback otherwise.
+func (o Optional[T]) OrElse(fallback T) T {
+	if o.Valid {
+		return o.Value
+	}
+	return fallback
+}
+
+func (o Optional[T]) isOptional() {}
+
+var optionalMarkerType = reflect.TypeOf((*interface{ isOptional() })(nil)).Elem()
+
+// isNullableType reports whether t is an Optional[T] or one of the database/sql
+// Null types (NullString, NullInt64, ..., Null[T]). All of them are a value field
+// followed by a Valid flag.
+func isNullableType(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return false
+	}
+	if valid := t.Field(1); valid.Name != "Valid" || valid.Type.Kind() != reflect.Bool {
+		return false
+	}
+	if t.Implements(optionalMarkerType) {
+		return true
+	}
+	return t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null")
+}
+
+// NullableCodec handles Optional[T] and the database/sql Null types.
+// The payload is a presence byte (0 or 1) followed, when present, by the value
+// encoded with the codec registered for its type.
+type NullableCodec struct {
+	registry *CodecRegistry
+	typ      reflect.Type
+	elemTag  byte
+	elemType reflect.Type
+}
+
+// newNullableCodec resolves the value field of the nullable type t and returns a codec for t.
+func newNullableCodec(r *CodecRegistry, t reflect.Type) (*NullableCodec, error) {
+	if !isNullableType(t) {
+		return nil, fmt.Errorf("type %v is not an Optional or sql.Null type", t)
+	}
+	elemType, elemTag, err := r.resolveType(t.Field(0).Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve value type of %v: %w", t, err)
+	}
+	return &NullableCodec{registry: r, typ: t, elemTag: elemTag, elemType: elemType}, nil
+}
+
+// registerNullable registers a NullableCodec for t under the given tag.
+// It panics if the value type cannot be resolved, which only happens when
+// RegisterPrimitives is incomplete.
+func (r *CodecRegistry) registerNullable(tag byte, exampleType interface{}) {
+	codec, err := newNullableCodec(r, reflect.TypeOf(exampleType))
+	if err != nil {
+		panic(err)
+	}
+	r.RegisterCodec(tag, codec, exampleType)
+}
+
+func (c *NullableCodec) Encode(value interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(value)
+	if rv.Type() != c.typ {
+		return nil, fmt.Errorf("value %v is not %v", value, c.typ)
+	}
+	if !rv.Field(1).Bool() {
+		return []byte{0}, nil
+	}
+
+	codec, err := c.registry.GetCodec(c.elemTag)
+	if err != nil {
+		return nil, err
+	}
+	data, err := codec.Encode(rv.Field(0).Interface())
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %v value: %w", c.typ, err)
+	}
+
+	result := make([]byte, 1+len(data))
+	result[0] = 1
+	copy(result[1:], data)
+	return result, nil
+}
+
+func (c *NullableCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid %v data: empty", c.typ)
+	}
+	if err := c.registry.opts.checkPresence(data[0], c.typ.String()); err != nil {
+		return nil, err
+	}
+
+	result := reflect.New(c.typ).Elem()
+	if data[0] == 0 {
+		return result.Interface(), nil
+	}
+
+	codec, err := c.registry.GetCodec(c.elemTag)
+	if err != nil {
+		return nil, err
+	}
+	elemVal, err := codec.Decode(data[1:])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %v value: %w", c.typ, err)
+	}
+
+	if elemVal != nil {
+		rv := reflect.ValueOf(elemVal)
+		if rv.Type().ConvertibleTo(c.elemType) {
+			rv = rv.Convert(c.elemType)
+		}
+		result.Field(0).Set(rv)
+	}
+	result.Field(1).SetBool(true)
+	return result.Interface(), nil
+}
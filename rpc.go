@@ -0,0 +1,253 @@
+package CryoDecoder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/rpc"
+	"reflect"
+	"sync"
+)
+
+// rpcHeader is sent before every net/rpc request and response body.
+type rpcHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+// rpcType is the registry used to encode and decode one root type. Every root type
+// is resolved on its own copy of the codec registry's primitives, interfaces and
+// custom codecs, so the tags its structs get depend only on the type, not on what
+// else either peer resolved or the order in which the peers first used their types.
+type rpcType struct {
+	mu       sync.Mutex // The registry may resolve further types while encoding
+	registry *CodecRegistry
+	tag      byte
+}
+
+// rpcCodec holds what the net/rpc server and client codecs share.
+type rpcCodec struct {
+	registry *CodecRegistry
+	conn     io.ReadWriteCloser
+	decoder  *Decoder
+	writeMu  sync.Mutex
+
+	typesMu sync.Mutex
+	types   map[reflect.Type]*rpcType
+}
+
+func newRPCCodec(registry *CodecRegistry, conn io.ReadWriteCloser) *rpcCodec {
+	return &rpcCodec{
+		registry: registry,
+		conn:     conn,
+		decoder:  NewDecoder(registry, bufio.NewReader(conn)),
+		types:    make(map[reflect.Type]*rpcType),
+	}
+}
+
+// typeFor returns the registry for t, resolving t the first time it is used.
+func (c *rpcCodec) typeFor(t reflect.Type) (*rpcType, error) {
+	c.typesMu.Lock()
+	defer c.typesMu.Unlock()
+	if rt, ok := c.types[t]; ok {
+		return rt, nil
+	}
+	// Keep the primitives and what the user registered explicitly, and drop the
+	// auto-assigned tags so that the remaining tags depend on nothing but t.
+	registry := c.registry.clone()
+	keep := c.registry.explicitTags()
+	maps.DeleteFunc(registry.codecs, func(tag byte, _ Codec) bool { return tag >= firstStructTag && !keep[tag] })
+	maps.DeleteFunc(registry.types, func(_ reflect.Type, tag byte) bool { return tag >= firstStructTag && !keep[tag] })
+	registry.nextStructTag = firstStructTag
+	for tag := range keep {
+		if tag >= registry.nextStructTag {
+			registry.nextStructTag = tag + 1
+		}
+	}
+
+	_, tag, err := registry.resolveType(t)
+	if err != nil {
+		return nil, fmt.Errorf("cannot register %v: %w", t, err)
+	}
+	rt := &rpcType{registry: registry, tag: tag}
+	c.types[t] = rt
+	return rt, nil
+}
+
+// explicitTags returns the tags at or above firstStructTag that were registered with
+// RegisterInterface or as custom codecs, together with every tag they refer to.
+// The per-type rpc registries keep these, since the peer has to register them the
+// same way for them to decode at all.
+func (r *CodecRegistry) explicitTags() map[byte]bool {
+	schema := r.Schema()
+	var roots []byte
+	for _, ts := range schema.Types {
+		if ts.Tag >= firstStructTag && (ts.Kind == KindInterface || ts.Kind == KindCustom) {
+			roots = append(roots, ts.Tag)
+		}
+	}
+	keep := make(map[byte]bool)
+	for _, ts := range schema.reachable(roots...).Types {
+		if ts.Tag >= firstStructTag {
+			keep[ts.Tag] = true
+		}
+	}
+	return keep
+}
+
+// encode returns a frame holding value. Pointers are dereferenced, so a body sent
+// as *T is received as T; a nil body is sent as an empty struct.
+func (c *rpcCodec) encode(value interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		rv = reflect.ValueOf(struct{}{})
+	}
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.Zero(rv.Type().Elem())
+		} else {
+			rv = rv.Elem()
+		}
+	}
+
+	rt, err := c.typeFor(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return NewEncoder(rt.registry).EncodeAs(rt.tag, rv.Interface())
+}
+
+// write sends the header and body frames in a single write.
+func (c *rpcCodec) write(header rpcHeader, body interface{}) error {
+	headerFrame, err := c.encode(header)
+	if err != nil {
+		return err
+	}
+	bodyFrame, err := c.encode(body)
+	if err != nil {
+		return fmt.Errorf("rpc: cannot encode body for %s: %w", header.ServiceMethod, err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.conn.Write(append(headerFrame, bodyFrame...))
+	return err
+}
+
+// read reads the next frame into the value target points to. A nil target discards
+// the frame. A closed connection is reported as io.EOF, as net/rpc expects.
+func (c *rpcCodec) read(target interface{}) error {
+	tag, payload, err := c.decoder.readFrame()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+	if target == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("rpc: cannot decode into non-pointer %T", target)
+	}
+	dest := rv.Elem()
+	rt, err := c.typeFor(dest.Type())
+	if err != nil {
+		return err
+	}
+	if tag != rt.tag {
+		return fmt.Errorf("rpc: expected %v (tag %d), got tag %d", dest.Type(), rt.tag, tag)
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	codec, err := rt.registry.GetCodec(tag)
+	if err != nil {
+		return err
+	}
+	value, err := codec.Decode(payload)
+	if err != nil {
+		return fmt.Errorf("rpc: cannot decode %v: %w", dest.Type(), err)
+	}
+	if value == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+	val := reflect.ValueOf(value)
+	if !val.Type().ConvertibleTo(dest.Type()) {
+		return fmt.Errorf("rpc: cannot convert decoded %v to %v", val.Type(), dest.Type())
+	}
+	dest.Set(val.Convert(dest.Type()))
+	return nil
+}
+
+func (c *rpcCodec) Close() error {
+	return c.conn.Close()
+}
+
+type serverCodec struct {
+	*rpcCodec
+}
+
+// NewServerCodec returns a net/rpc ServerCodec that exchanges CryoDecoder frames
+// over conn. Argument and reply types are registered automatically the first time
+// they are used; registry supplies the options and primitive codecs (tags below 200).
+// Each header and body is a separate frame; bodies sent by pointer arrive by value.
+//
+//	go rpc.ServeCodec(cryodecoder.NewServerCodec(registry, conn))
+func NewServerCodec(registry *CodecRegistry, conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{newRPCCodec(registry, conn)}
+}
+
+func (c *serverCodec) ReadRequestHeader(req *rpc.Request) error {
+	var header rpcHeader
+	if err := c.read(&header); err != nil {
+		return err
+	}
+	req.ServiceMethod, req.Seq = header.ServiceMethod, header.Seq
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return c.read(body)
+}
+
+func (c *serverCodec) WriteResponse(resp *rpc.Response, body interface{}) error {
+	return c.write(rpcHeader{ServiceMethod: resp.ServiceMethod, Seq: resp.Seq, Error: resp.Error}, body)
+}
+
+type clientCodec struct {
+	*rpcCodec
+}
+
+// NewClientCodec returns a net/rpc ClientCodec that exchanges CryoDecoder frames
+// over conn. It is the counterpart of NewServerCodec.
+//
+//	client := rpc.NewClientWithCodec(cryodecoder.NewClientCodec(registry, conn))
+func NewClientCodec(registry *CodecRegistry, conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{newRPCCodec(registry, conn)}
+}
+
+func (c *clientCodec) WriteRequest(req *rpc.Request, body interface{}) error {
+	return c.write(rpcHeader{ServiceMethod: req.ServiceMethod, Seq: req.Seq}, body)
+}
+
+func (c *clientCodec) ReadResponseHeader(resp *rpc.Response) error {
+	var header rpcHeader
+	if err := c.read(&header); err != nil {
+		return err
+	}
+	resp.ServiceMethod, resp.Seq, resp.Error = header.ServiceMethod, header.Seq, header.Error
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return c.read(body)
+}
@@ -0,0 +1,134 @@
+package CryoDecoder
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type ArithArgs struct {
+	A, B int64
+}
+
+type ArithQuotient struct {
+	Quo, Rem int64
+}
+
+type ArithReport struct {
+	Label  string
+	Values []int64
+	Totals map[string]int64
+}
+
+type Arith struct{}
+
+func (Arith) Multiply(args *ArithArgs, reply *int64) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (Arith) Divide(args *ArithArgs, reply *ArithQuotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = ArithQuotient{Quo: args.A / args.B, Rem: args.A % args.B}
+	return nil
+}
+
+func (Arith) Report(args *ArithArgs, reply *ArithReport) error {
+	*reply = ArithReport{
+		Label:  "range",
+		Values: []int64{args.A, args.B},
+		Totals: map[string]int64{"sum": args.A + args.B},
+	}
+	return nil
+}
+
+// rpcPair serves Arith on one end of a pipe and returns a client for the other.
+func rpcPair(t *testing.T) *rpc.Client {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	serverRegistry := NewCodecRegistry()
+	serverRegistry.RegisterPrimitives()
+	// A type only the server has used must not shift the tags of the others.
+	if _, err := serverRegistry.RegisterStruct(ArithReport{}); err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeCodec(NewServerCodec(serverRegistry, a))
+
+	clientRegistry := NewCodecRegistry()
+	clientRegistry.RegisterPrimitives()
+	client := rpc.NewClientWithCodec(NewClientCodec(clientRegistry, b))
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestNetRPCCodecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		args    ArithArgs
+		reply   interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{name: "primitive reply", method: "Arith.Multiply", args: ArithArgs{A: 7, B: 6}, reply: new(int64), want: int64(42)},
+		{name: "struct reply", method: "Arith.Divide", args: ArithArgs{A: 17, B: 5}, reply: new(ArithQuotient), want: ArithQuotient{Quo: 3, Rem: 2}},
+		{
+			name: "nested reply", method: "Arith.Report", args: ArithArgs{A: 2, B: 3}, reply: new(ArithReport),
+			want: ArithReport{Label: "range", Values: []int64{2, 3}, Totals: map[string]int64{"sum": 5}},
+		},
+		{name: "service error", method: "Arith.Divide", args: ArithArgs{A: 1}, reply: new(ArithQuotient), wantErr: "divide by zero"},
+		{name: "unknown method", method: "Arith.Subtract", args: ArithArgs{}, reply: new(int64), wantErr: "rpc: can't find method Arith.Subtract"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := rpcPair(t)
+			err := client.Call(tt.method, &tt.args, tt.reply)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reflect.ValueOf(tt.reply).Elem().Interface(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNetRPCCodecsConcurrentCalls issues calls of different types at once, so the
+// types are resolved while other calls are encoding.
+func TestNetRPCCodecsConcurrentCalls(t *testing.T) {
+	client := rpcPair(t)
+	var wg sync.WaitGroup
+	for i := int64(1); i <= 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var product int64
+			if err := client.Call("Arith.Multiply", &ArithArgs{A: i, B: i}, &product); err != nil || product != i*i {
+				t.Errorf("Multiply(%d, %d) = %d, %v", i, i, product, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var q ArithQuotient
+			if err := client.Call("Arith.Divide", &ArithArgs{A: 100, B: i}, &q); err != nil || q.Quo != 100/i {
+				t.Errorf("Divide(100, %d) = %+v, %v", i, q, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
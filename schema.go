@@ -0,0 +1,304 @@
+package CryoDecoder
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SchemaKind describes how a registered tag is encoded on the wire.
+type SchemaKind string
+
+const (
+	KindPrimitive       SchemaKind = "primitive"      // a built-in codec identified by its type name
+	KindStruct          SchemaKind = "struct"         // Fields in order
+	KindSlice           SchemaKind = "slice"          // Elem
+	KindArray           SchemaKind = "array"          // Elem and Len
+	KindMap             SchemaKind = "map"            // Key and Value
+	KindSet             SchemaKind = "set"            // Key
+	KindPointer         SchemaKind = "pointer"        // Elem
+	KindNullable        SchemaKind = "nullable"       // Elem
+	KindInterface       SchemaKind = "interface"      // Impls
+	KindAny             SchemaKind = "any"            // interface{}: any registered tag
+	KindMapStringAny    SchemaKind = "map[string]any" // string keys, interface{} values
+	KindBinaryMarshaler SchemaKind = "binary-marshaler"
+	KindTextMarshaler   SchemaKind = "text-marshaler"
+	KindGobEncoder      SchemaKind = "gob-encoder"
+	KindJSONMarshaler   SchemaKind = "json-marshaler"
+	KindCustom          SchemaKind = "custom" // a codec registered with RegisterCodec
+)
+
+// Schema is a machine-readable description of every tag in a CodecRegistry.
+// It can be exported as JSON or sent as a CryoDecoder frame (tag 43) so that other
+// services and tools can inspect what a peer speaks.
+type Schema struct {
+	Types []TypeSchema `json:"types"`
+}
+
+// TypeSchema describes a single registered tag. Elem, Key and Value are tags of
+// other entries in the same schema; 0 means unused.
+type TypeSchema struct {
+	Tag    byte          `json:"tag"`
+	Name   string        `json:"name"`
+	Kind   SchemaKind    `json:"kind"`
+	Fields []FieldSchema `json:"fields,omitempty"`
+	Elem   byte          `json:"elem,omitempty"`
+	Key    byte          `json:"key,omitempty"`
+	Value  byte          `json:"value,omitempty"`
+	Len    int           `json:"len,omitempty"`
+	Impls  []byte        `json:"impls,omitempty"`
+}
+
+// FieldSchema describes a struct field and the tag its value is encoded with.
+type FieldSchema struct {
+	Name string `json:"name"`
+	Tag  byte   `json:"tag"`
+}
+
+// Lookup returns the entry for tag.
+func (s Schema) Lookup(tag byte) (TypeSchema, bool) {
+	i := sort.Search(len(s.Types), func(i int) bool { return s.Types[i].Tag >= tag })
+	if i < len(s.Types) && s.Types[i].Tag == tag {
+		return s.Types[i], true
+	}
+	return TypeSchema{}, false
+}
+
+// Schema describes every tag registered in r, ordered by tag.
+func (r *CodecRegistry) Schema() Schema {
+	// Several types can share a tag (e.g. interface{} and []interface{} on tag 18).
+	// Pick the shortest type name so the result does not depend on map order.
+	names := make(map[byte]string, len(r.codecs))
+	for t, tag := range r.types {
+		name := t.String()
+		if prev, ok := names[tag]; !ok || len(name) < len(prev) || (len(name) == len(prev) && name < prev) {
+			names[tag] = name
+		}
+	}
+
+	schema := Schema{Types: make([]TypeSchema, 0, len(r.codecs))}
+	for tag, codec := range r.codecs {
+		ts := TypeSchema{Tag: tag, Name: names[tag]}
+		r.describeCodec(&ts, codec)
+		schema.Types = append(schema.Types, ts)
+	}
+	sort.Slice(schema.Types, func(i, j int) bool { return schema.Types[i].Tag < schema.Types[j].Tag })
+	return schema
+}
+
+// reachable returns the entries of s for tags and for every tag they refer to.
+func (s Schema) reachable(tags ...byte) Schema {
+	seen := make(map[byte]bool)
+	var result Schema
+	var visit func(tag byte)
+	visit = func(tag byte) {
+		ts, ok := s.Lookup(tag)
+		if !ok || seen[tag] {
+			return
+		}
+		seen[tag] = true
+		result.Types = append(result.Types, ts)
+		for _, ref := range append([]byte{ts.Elem, ts.Key, ts.Value}, ts.Impls...) {
+			visit(ref)
+		}
+		for _, field := range ts.Fields {
+			visit(field.Tag)
+		}
+	}
+	for _, tag := range tags {
+		visit(tag)
+	}
+	sort.Slice(result.Types, func(i, j int) bool { return result.Types[i].Tag < result.Types[j].Tag })
+	return result
+}
+
+// describeCodec fills in the kind and the referenced tags of ts from its codec.
+func (r *CodecRegistry) describeCodec(ts *TypeSchema, codec Codec) {
+	switch c := codec.(type) {
+	case *StructCodec:
+		ts.Kind = KindStruct
+		ts.Fields = make([]FieldSchema, len(c.fields))
+		for i, field := range c.fields {
+			ts.Fields[i] = FieldSchema{Name: field.name, Tag: field.typeTag}
+		}
+	case *SliceCodec:
+		ts.Kind = KindSlice
+		ts.Elem = r.types[c.elemType]
+	case *ArrayCodec:
+		ts.Kind = KindArray
+		ts.Elem = r.types[c.elemType]
+		ts.Len = c.arrayLen
+	case *MapCodec:
+		ts.Kind = KindMap
+		ts.Key = r.types[c.keyType]
+		ts.Value = r.types[c.valType]
+	case *SetCodec:
+		ts.Kind = KindSet
+		ts.Key = r.types[c.keyType]
+	case *PointerCodec:
+		ts.Kind = KindPointer
+		ts.Elem = r.types[c.elemType]
+	case *NullableCodec:
+		ts.Kind = KindNullable
+		ts.Elem = c.elemTag
+	case *InterfaceTypeCodec:
+		ts.Kind = KindInterface
+		for tag := range c.impls {
+			ts.Impls = append(ts.Impls, tag)
+		}
+		sort.Slice(ts.Impls, func(i, j int) bool { return ts.Impls[i] < ts.Impls[j] })
+	case *InterfaceCodec:
+		ts.Kind = KindAny
+	case *MapStringAnyCodec:
+		ts.Kind = KindMapStringAny
+	case *MarshalerCodec:
+		ts.Kind = KindBinaryMarshaler
+	case *TextMarshalerCodec:
+		ts.Kind = KindTextMarshaler
+	case *GobEncoderCodec:
+		ts.Kind = KindGobEncoder
+	case *JSONMarshalerCodec:
+		ts.Kind = KindJSONMarshaler
+	case *Int32Codec, *Int64Codec, *IntCodec, *Int8Codec, *Int16Codec,
+		*Uint8Codec, *Uint16Codec, *Uint32Codec, *Uint64Codec, *UintCodec, *UintptrCodec,
+		*Float32Codec, *Float64Codec, *Complex64Codec, *Complex128Codec,
+		*BoolCodec, *StringCodec, *LocationCodec, *TimeCodec, *DurationCodec,
+		*BigIntCodec, *BigFloatCodec, *BigRatCodec, *DecimalCodec,
+		*NetipAddrCodec, *NetipAddrPortCodec, *NetipPrefixCodec, *NetIPCodec, *NetHardwareAddrCodec, *UUIDCodec,
+		*BoolSliceCodec, *BitsetCodec, *SchemaCodec, *HelloCodec, *rpcMessageCodec, *streamFrameCodec, *controlFrameCodec:
+		ts.Kind = KindPrimitive
+	default:
+		ts.Kind = KindCustom
+	}
+}
+
+// SchemaJSON exports the schema of r as indented JSON.
+func (r *CodecRegistry) SchemaJSON() ([]byte, error) {
+	return json.MarshalIndent(r.Schema(), "", "  ")
+}
+
+// SchemaCodec handles Schema. It is registered as a primitive so that a peer can
+// decode a schema frame before it knows anything else about the sender.
+// The payload is the number of types (uvarint) followed by, per type: the tag, the
+// name and kind (uvarint length plus bytes), the Elem, Key and Value tags, Len as a
+// uvarint, the fields (uvarint count, then name and tag each) and the implementation
+// tags (uvarint count, then one byte each).
+type SchemaCodec struct{}
+
+func appendSchemaString(buf []byte, s string) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+func (c *SchemaCodec) Encode(value interface{}) ([]byte, error) {
+	s, ok := value.(Schema)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not Schema", value)
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(len(s.Types)))
+	for _, ts := range s.Types {
+		if ts.Len < 0 {
+			return nil, fmt.Errorf("invalid length %d for tag %d", ts.Len, ts.Tag)
+		}
+		buf = append(buf, ts.Tag)
+		buf = appendSchemaString(buf, ts.Name)
+		buf = appendSchemaString(buf, string(ts.Kind))
+		buf = append(buf, ts.Elem, ts.Key, ts.Value)
+		buf = binary.AppendUvarint(buf, uint64(ts.Len))
+		buf = binary.AppendUvarint(buf, uint64(len(ts.Fields)))
+		for _, field := range ts.Fields {
+			buf = appendSchemaString(buf, field.Name)
+			buf = append(buf, field.Tag)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(ts.Impls)))
+		buf = append(buf, ts.Impls...)
+	}
+	return buf, nil
+}
+
+// schemaReader reads the SchemaCodec layout and remembers the first error.
+type schemaReader struct {
+	data []byte
+	err  error
+}
+
+func (sr *schemaReader) fail(format string, args ...interface{}) {
+	if sr.err == nil {
+		sr.err = fmt.Errorf("invalid schema data: "+format, args...)
+	}
+}
+
+func (sr *schemaReader) byte() byte {
+	if len(sr.data) < 1 {
+		sr.fail("unexpected end of data")
+		return 0
+	}
+	b := sr.data[0]
+	sr.data = sr.data[1:]
+	return b
+}
+
+// count reads a uvarint that is bounded by the number of remaining bytes.
+func (sr *schemaReader) count() int {
+	v, n := binary.Uvarint(sr.data)
+	if n <= 0 {
+		sr.fail("invalid uvarint")
+		return 0
+	}
+	sr.data = sr.data[n:]
+	if v > uint64(len(sr.data)) {
+		sr.fail("count %d exceeds remaining %d bytes", v, len(sr.data))
+		return 0
+	}
+	return int(v)
+}
+
+// length reads an array length, which unlike a count is not bounded by the data.
+func (sr *schemaReader) length() int {
+	v, n := binary.Uvarint(sr.data)
+	if n <= 0 || v > math.MaxInt32 {
+		sr.fail("invalid length")
+		return 0
+	}
+	sr.data = sr.data[n:]
+	return int(v)
+}
+
+func (sr *schemaReader) string() string {
+	n := sr.count()
+	s := string(sr.data[:n])
+	sr.data = sr.data[n:]
+	return s
+}
+
+func (c *SchemaCodec) Decode(data []byte) (interface{}, error) {
+	sr := &schemaReader{data: data}
+	count := sr.count()
+	s := Schema{Types: make([]TypeSchema, 0, count)}
+	for i := 0; i < count && sr.err == nil; i++ {
+		ts := TypeSchema{Tag: sr.byte(), Name: sr.string(), Kind: SchemaKind(sr.string())}
+		ts.Elem, ts.Key, ts.Value = sr.byte(), sr.byte(), sr.byte()
+		ts.Len = sr.length()
+		if n := sr.count(); n > 0 {
+			ts.Fields = make([]FieldSchema, n)
+			for j := range ts.Fields {
+				ts.Fields[j] = FieldSchema{Name: sr.string(), Tag: sr.byte()}
+			}
+		}
+		if n := sr.count(); n > 0 {
+			ts.Impls = append([]byte(nil), sr.data[:n]...)
+			sr.data = sr.data[n:]
+		}
+		s.Types = append(s.Types, ts)
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if len(sr.data) != 0 {
+		return nil, fmt.Errorf("invalid schema data: %d trailing bytes", len(sr.data))
+	}
+	sort.Slice(s.Types, func(i, j int) bool { return s.Types[i].Tag < s.Types[j].Tag })
+	return s, nil
+}
@@ -0,0 +1,552 @@
+package CryoDecoder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StreamWindow is the number of bytes a peer may send on a stream before the
+// receiver has read them and granted more. Every stream starts with this window in
+// both directions.
+const StreamWindow = 256 << 10
+
+// maxStreamChunk is the largest payload of a single data frame, so that a bulk
+// transfer on one stream does not hold up frames for the others for long.
+const maxStreamChunk = 16 << 10
+
+// streamAcceptBacklog is the number of opened streams that may wait for Accept
+// before further ones are reset.
+const streamAcceptBacklog = 64
+
+// maxPendingResets is the number of resets the read loop may queue for the peer
+// before it gives up on a peer that keeps opening streams it cannot have.
+const maxPendingResets = 1024
+
+// Frame kinds of a streamFrame.
+const (
+	streamOpen   byte = iota + 1 // the sender opened the stream
+	streamData                   // Data is the next part of the stream
+	streamWindow                 // the receiver read Delta more bytes
+	streamClose                  // the sender will send no more data
+	streamReset                  // the sender aborted the stream
+)
+
+// streamFrame is the frame exchanged by Sessions (tag 46). Every frame carries the
+// ID of the stream it belongs to.
+type streamFrame struct {
+	Kind     byte
+	StreamID uint32
+	Data     []byte // Data frames only
+	Delta    uint32 // Window frames only
+}
+
+// streamFrameCodec handles streamFrame as the kind byte and the stream ID (uvarint)
+// followed by, for data frames, the data, and for window frames, the delta (uvarint).
+type streamFrameCodec struct{}
+
+func (c *streamFrameCodec) Encode(value interface{}) ([]byte, error) {
+	f, ok := value.(streamFrame)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not streamFrame", value)
+	}
+	buf := binary.AppendUvarint([]byte{f.Kind}, uint64(f.StreamID))
+	switch f.Kind {
+	case streamData:
+		buf = append(buf, f.Data...)
+	case streamWindow:
+		buf = binary.AppendUvarint(buf, uint64(f.Delta))
+	case streamOpen, streamClose, streamReset:
+	default:
+		return nil, fmt.Errorf("invalid stream frame kind %d", f.Kind)
+	}
+	return buf, nil
+}
+
+func (c *streamFrameCodec) Decode(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("invalid data length for stream frame: 0")
+	}
+	f := streamFrame{Kind: data[0]}
+	id, n := binary.Uvarint(data[1:])
+	if n <= 0 || id > 1<<32-1 {
+		return nil, fmt.Errorf("invalid stream ID")
+	}
+	f.StreamID, data = uint32(id), data[1+n:]
+
+	switch f.Kind {
+	case streamData:
+		f.Data, data = data, nil
+	case streamWindow:
+		delta, n := binary.Uvarint(data)
+		if n <= 0 || delta > 1<<32-1 {
+			return nil, fmt.Errorf("invalid stream window delta")
+		}
+		f.Delta, data = uint32(delta), data[n:]
+	case streamOpen, streamClose, streamReset:
+	default:
+		return nil, fmt.Errorf("invalid stream frame kind %d", f.Kind)
+	}
+	if len(data) != 0 {
+		return nil, fmt.Errorf("invalid stream frame: %d trailing bytes", len(data))
+	}
+	return f, nil
+}
+
+var (
+	// ErrSessionClosed is returned by a Session, and its streams, once it is closed
+	// or its connection fails.
+	ErrSessionClosed = errors.New("session closed")
+	// ErrStreamClosed is returned when writing to a stream after closing it.
+	ErrStreamClosed = errors.New("stream closed")
+	// ErrStreamReset is returned by a stream that either side has reset.
+	ErrStreamReset = errors.New("stream reset")
+)
+
+// Session multiplexes any number of streams over a single connection, so that a bulk
+// transfer on one stream does not block small messages on another. Each stream has
+// its own flow-control window, so a stream whose reader falls behind stalls only its
+// own writer. The two ends of a connection must be created with NewClientSession and
+// NewServerSession respectively; either end may open streams.
+type Session struct {
+	registry *CodecRegistry
+	conn     io.ReadWriteCloser
+	writeMu  sync.Mutex // Guards encoder and writes to conn
+	encoder  *Encoder
+
+	mu      sync.Mutex // Guards streams, nextID, resets and err
+	streams map[uint32]*Stream
+	nextID  uint32
+	resets  []uint32      // Streams to reset, written by resetLoop
+	wake    chan struct{} // Signals resetLoop that resets were queued
+	err     error         // Set once the session is closed or the connection fails
+	accept  chan *Stream
+	done    chan struct{}
+}
+
+// NewClientSession returns the session for the end of conn that dialed. Streams use
+// registry for their Encoders and Decoders; since streams may encode concurrently,
+// register every type before opening streams.
+func NewClientSession(registry *CodecRegistry, conn io.ReadWriteCloser) *Session {
+	return newSession(registry, conn, 1)
+}
+
+// NewServerSession returns the session for the end of conn that accepted it.
+func NewServerSession(registry *CodecRegistry, conn io.ReadWriteCloser) *Session {
+	return newSession(registry, conn, 2)
+}
+
+// newSession starts a session whose streams get IDs firstID, firstID+2 and so on, so
+// that the two ends never pick the same ID.
+func newSession(registry *CodecRegistry, conn io.ReadWriteCloser, firstID uint32) *Session {
+	// Session frames use a registry of their own, so that reading them never races
+	// with streams resolving types in the caller's registry.
+	frames := NewCodecRegistry()
+	frames.RegisterCodec(46, &streamFrameCodec{}, streamFrame{})
+
+	s := &Session{
+		registry: registry,
+		conn:     conn,
+		encoder:  NewEncoder(frames),
+		streams:  make(map[uint32]*Stream),
+		nextID:   firstID,
+		wake:     make(chan struct{}, 1),
+		accept:   make(chan *Stream, streamAcceptBacklog),
+		done:     make(chan struct{}),
+	}
+	go s.readLoop(NewDecoder(frames, bufio.NewReader(conn)))
+	go s.resetLoop()
+	return s
+}
+
+// Open opens a new stream. The peer sees it once the first frame arrives; data can be
+// written straight away.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	if s.nextID > 1<<32-3 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("session has run out of stream IDs")
+	}
+	st := newStream(s, s.nextID)
+	s.nextID += 2
+	s.streams[st.id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(streamFrame{Kind: streamOpen, StreamID: st.id}); err != nil {
+		s.remove(st.id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for the peer to open a stream.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		// Hand out streams that were opened before the session closed.
+		select {
+		case st := <-s.accept:
+			return st, nil
+		default:
+			return nil, s.closeErr()
+		}
+	}
+}
+
+// Close closes the connection. Streams still open fail with ErrSessionClosed.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return s.conn.Close()
+}
+
+// Done is closed once the session is closed or its connection fails.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// fail closes the session with err and wakes every stream.
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	close(s.done)
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		st.mu.Lock()
+		st.cond.Broadcast()
+		st.mu.Unlock()
+	}
+}
+
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) writeFrame(f streamFrame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.closeErr(); err != nil {
+		return err
+	}
+	frame, err := s.encoder.Encode(f)
+	if err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		s.fail(ErrSessionClosed)
+		return fmt.Errorf("%w: %v", ErrSessionClosed, err)
+	}
+	return nil
+}
+
+// queueReset asks resetLoop to reset the stream id. It reports false if too many
+// resets are already waiting.
+func (s *Session) queueReset(id uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.resets) >= maxPendingResets {
+		return false
+	}
+	s.resets = append(s.resets, id)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// resetLoop writes the resets queued by the read loop, in order, until the session
+// closes.
+func (s *Session) resetLoop() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+		s.mu.Lock()
+		ids := s.resets
+		s.resets = nil
+		s.mu.Unlock()
+		for _, id := range ids {
+			if err := s.writeFrame(streamFrame{Kind: streamReset, StreamID: id}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLoop reads frames until the connection fails. It never blocks on writing, so
+// that two sessions replying to each other cannot deadlock: resets it sends are
+// queued for resetLoop.
+func (s *Session) readLoop(decoder *Decoder) {
+	for {
+		value, err := decoder.Decode()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				s.fail(ErrSessionClosed)
+			} else {
+				s.fail(fmt.Errorf("%w: %v", ErrSessionClosed, err))
+			}
+			return
+		}
+		f, ok := value.(streamFrame)
+		if !ok {
+			s.fail(fmt.Errorf("%w: expected a stream frame, got %T", ErrSessionClosed, value))
+			return
+		}
+		if !s.handleFrame(f) {
+			s.fail(fmt.Errorf("%w: peer opened more than %d streams that had to be reset", ErrSessionClosed, maxPendingResets))
+			return
+		}
+	}
+}
+
+// handleFrame applies f. It reports false if a reset could not be queued.
+func (s *Session) handleFrame(f streamFrame) bool {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return true
+	}
+	st, ok := s.streams[f.StreamID]
+	if f.Kind == streamOpen && !ok {
+		// The peer may only open IDs of the other parity than ours; anything else
+		// would collide with a stream we open later.
+		if f.StreamID == 0 || f.StreamID%2 == s.nextID%2 {
+			s.mu.Unlock()
+			return s.queueReset(f.StreamID)
+		}
+		st = newStream(s, f.StreamID)
+		select {
+		case s.accept <- st:
+			s.streams[f.StreamID] = st
+		default:
+			s.mu.Unlock()
+			return s.queueReset(f.StreamID)
+		}
+	}
+	s.mu.Unlock()
+	if !ok && f.Kind != streamOpen {
+		// Frames may still arrive for a stream that was just reset.
+		return true
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch f.Kind {
+	case streamData:
+		if len(f.Data) > st.recvWindow {
+			// The peer ignored the window: abort the stream rather than buffer it.
+			st.resetLocked()
+			st.cond.Broadcast()
+			return s.queueReset(st.id)
+		}
+		st.recvWindow -= len(f.Data)
+		st.readBuf.Write(f.Data)
+	case streamWindow:
+		st.sendWindow += int(f.Delta)
+	case streamClose:
+		st.readClosed = true
+		if st.writeClosed {
+			s.remove(st.id)
+		}
+	case streamReset:
+		st.resetLocked()
+	}
+	st.cond.Broadcast()
+	return true
+}
+
+// Stream is one logical stream of a Session. Read and Write carry raw bytes; Send and
+// Receive carry frames through the stream's own Encoder and Decoder.
+type Stream struct {
+	id      uint32
+	session *Session
+	encoder *Encoder
+	decoder *Decoder
+
+	mu          sync.Mutex
+	cond        *sync.Cond // Signaled whenever any of the fields below change
+	readBuf     bytes.Buffer
+	recvWindow  int // Bytes the peer may still send
+	consumed    int // Bytes read but not yet granted back to the peer
+	sendWindow  int // Bytes we may still send
+	readClosed  bool
+	writeClosed bool
+	reset       bool
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	st := &Stream{
+		id:         id,
+		session:    s,
+		encoder:    NewEncoder(s.registry),
+		recvWindow: StreamWindow,
+		sendWindow: StreamWindow,
+	}
+	st.cond = sync.NewCond(&st.mu)
+	st.decoder = NewDecoder(s.registry, st)
+	return st
+}
+
+// ID returns the stream's ID, which is odd for streams opened by the client session
+// and even for those opened by the server session.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Encoder returns the stream's Encoder, for example to enable compression.
+func (st *Stream) Encoder() *Encoder {
+	return st.encoder
+}
+
+// Decoder returns the stream's Decoder, which reads frames from the stream.
+func (st *Stream) Decoder() *Decoder {
+	return st.decoder
+}
+
+// Send encodes value with the stream's Encoder and writes the frame.
+func (st *Stream) Send(value interface{}) error {
+	frame, err := st.encoder.Encode(value)
+	if err != nil {
+		return err
+	}
+	_, err = st.Write(frame)
+	return err
+}
+
+// Receive reads the next frame with the stream's Decoder.
+func (st *Stream) Receive() (interface{}, error) {
+	return st.decoder.Decode()
+}
+
+// Read reads data sent by the peer. It returns io.EOF once the peer has closed the
+// stream and everything it sent has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for st.readBuf.Len() == 0 && !st.readClosed && !st.reset && st.session.closeErr() == nil {
+		st.cond.Wait()
+	}
+	switch {
+	case st.reset:
+		st.mu.Unlock()
+		return 0, ErrStreamReset
+	case st.readBuf.Len() == 0 && st.readClosed:
+		st.mu.Unlock()
+		return 0, io.EOF
+	case st.readBuf.Len() == 0:
+		st.mu.Unlock()
+		return 0, st.session.closeErr()
+	}
+
+	n, _ := st.readBuf.Read(p)
+	st.consumed += n
+	// Grant the window back in batches rather than after every read.
+	var delta int
+	if st.consumed >= StreamWindow/2 && !st.readClosed {
+		delta, st.consumed = st.consumed, 0
+		st.recvWindow += delta
+	}
+	st.mu.Unlock()
+
+	if delta > 0 {
+		st.session.writeFrame(streamFrame{Kind: streamWindow, StreamID: st.id, Delta: uint32(delta)})
+	}
+	return n, nil
+}
+
+// Write sends p to the peer, blocking while the stream's send window is used up.
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && !st.writeClosed && !st.reset && st.session.closeErr() == nil {
+			st.cond.Wait()
+		}
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return written, ErrStreamReset
+		case st.writeClosed:
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		case st.sendWindow == 0:
+			st.mu.Unlock()
+			return written, st.session.closeErr()
+		}
+		n := min(len(p), st.sendWindow, maxStreamChunk)
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(streamFrame{Kind: streamData, StreamID: st.id, Data: p[:n]}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the stream for writing; the peer reads io.EOF once it has read
+// everything sent before. Data from the peer can still be read until it closes its
+// side too.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.writeClosed || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	if st.readClosed {
+		st.session.remove(st.id)
+	}
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	return st.session.writeFrame(streamFrame{Kind: streamClose, StreamID: st.id})
+}
+
+// Reset aborts the stream in both directions. Unread data is discarded and both sides
+// get ErrStreamReset from then on.
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.resetLocked()
+	st.cond.Broadcast()
+	st.mu.Unlock()
+	return st.session.writeFrame(streamFrame{Kind: streamReset, StreamID: st.id})
+}
+
+// resetLocked marks the stream reset and forgets it. st.mu must be held.
+func (st *Stream) resetLocked() {
+	st.reset = true
+	st.readBuf.Reset()
+	st.session.remove(st.id)
+}
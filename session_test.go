@@ -0,0 +1,298 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// rawSessionPeer is the far end of a Session's connection, speaking stream frames
+// directly so tests can send frames a well-behaved Session never would.
+type rawSessionPeer struct {
+	conn    net.Conn
+	encoder *Encoder
+	frames  chan streamFrame // Frames received from the session
+}
+
+func newRawSessionPeer(conn net.Conn) *rawSessionPeer {
+	frames := NewCodecRegistry()
+	frames.RegisterCodec(46, &streamFrameCodec{}, streamFrame{})
+	p := &rawSessionPeer{conn: conn, encoder: NewEncoder(frames), frames: make(chan streamFrame, 1024)}
+	go func() {
+		decoder := NewDecoder(frames, conn)
+		for {
+			value, err := decoder.Decode()
+			if err != nil {
+				close(p.frames)
+				return
+			}
+			p.frames <- value.(streamFrame)
+		}
+	}()
+	return p
+}
+
+func (p *rawSessionPeer) send(t *testing.T, f streamFrame) {
+	t.Helper()
+	frame, err := p.encoder.Encode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// next returns the next frame the session sent.
+func (p *rawSessionPeer) next(t *testing.T) streamFrame {
+	t.Helper()
+	select {
+	case f, ok := <-p.frames:
+		if !ok {
+			t.Fatal("session closed the connection")
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a frame")
+	}
+	return streamFrame{}
+}
+
+func TestSessionRejectsPeerStreamIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		opens  []uint32 // stream IDs the client peer opens on a server session
+		resets []uint32 // stream IDs the server must reset, in order
+	}{
+		{name: "client parity", opens: []uint32{1, 3}},
+		{name: "server parity", opens: []uint32{2, 1}, resets: []uint32{2}},
+		{name: "zero", opens: []uint32{0, 1}, resets: []uint32{0}},
+		{name: "accept backlog full", opens: backlogIDs(streamAcceptBacklog + 2), resets: []uint32{2*streamAcceptBacklog + 1, 2*streamAcceptBacklog + 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			server := NewServerSession(NewCodecRegistry(), a)
+			defer server.Close()
+			peer := newRawSessionPeer(b)
+
+			for _, id := range tt.opens {
+				peer.send(t, streamFrame{Kind: streamOpen, StreamID: id})
+			}
+			for _, id := range tt.resets {
+				if f := peer.next(t); f.Kind != streamReset || f.StreamID != id {
+					t.Fatalf("got frame kind %d for stream %d, want a reset of stream %d", f.Kind, f.StreamID, id)
+				}
+			}
+
+			// Streams the server opens itself keep the server's parity and are not
+			// confused with anything the peer sent.
+			st, err := server.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f := peer.next(t); f.Kind != streamOpen || f.StreamID != st.ID() || st.ID() != 2 {
+				t.Fatalf("got frame kind %d for stream %d, want stream 2 opened", f.Kind, f.StreamID)
+			}
+			for range len(tt.opens) - len(tt.resets) {
+				accepted, err := server.Accept()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if accepted.ID()%2 != 1 {
+					t.Errorf("accepted stream %d opened with the server's parity", accepted.ID())
+				}
+			}
+		})
+	}
+}
+
+// backlogIDs returns the first n client stream IDs.
+func backlogIDs(n int) []uint32 {
+	ids := make([]uint32, n)
+	for i := range ids {
+		ids[i] = uint32(2*i + 1)
+	}
+	return ids
+}
+
+// sessionPair returns the client and server sessions of a pipe.
+func sessionPair(t *testing.T) (*Session, *Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	client := NewClientSession(newConnRegistry(), a)
+	server := NewServerSession(newConnRegistry(), b)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// openPair opens a stream on client and accepts it on server.
+func openPair(t *testing.T, client, server *Session) (*Stream, *Stream) {
+	t.Helper()
+	local, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return local, remote
+}
+
+// writeAsync writes data to st in the background and reports the result.
+func writeAsync(st *Stream, data []byte) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := st.Write(data)
+		done <- err
+	}()
+	return done
+}
+
+func TestSessionStreams(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, client, server *Session)
+	}{
+		{
+			name: "writer blocks until the reader grants window",
+			run: func(t *testing.T, client, server *Session) {
+				local, remote := openPair(t, client, server)
+				data := bytes.Repeat([]byte("x"), StreamWindow+maxStreamChunk)
+				written := writeAsync(local, data)
+				select {
+				case err := <-written:
+					t.Fatalf("write of more than the window finished early: %v", err)
+				case <-time.After(100 * time.Millisecond):
+				}
+				got := make([]byte, len(data))
+				if _, err := io.ReadFull(remote, got); err != nil {
+					t.Fatal(err)
+				}
+				if err := <-written; err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatal("data corrupted")
+				}
+			},
+		},
+		{
+			name: "a stalled stream does not block others",
+			run: func(t *testing.T, client, server *Session) {
+				stalled, _ := openPair(t, client, server)
+				writeAsync(stalled, bytes.Repeat([]byte("x"), 2*StreamWindow))
+				local, remote := openPair(t, client, server)
+				if err := local.Send("ping"); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := remote.Receive(); err != nil || got != "ping" {
+					t.Fatalf("got %v, %v", got, err)
+				}
+			},
+		},
+		{
+			name: "close delivers EOF after the data",
+			run: func(t *testing.T, client, server *Session) {
+				local, remote := openPair(t, client, server)
+				if _, err := local.Write([]byte("bye")); err != nil {
+					t.Fatal(err)
+				}
+				if err := local.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if got, err := io.ReadAll(remote); err != nil || string(got) != "bye" {
+					t.Fatalf("got %q, %v", got, err)
+				}
+				if _, err := local.Write([]byte("more")); !errors.Is(err, ErrStreamClosed) {
+					t.Fatalf("got %v, want ErrStreamClosed", err)
+				}
+				// The other direction stays open.
+				if _, err := remote.Write([]byte("ack")); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 3)
+				if _, err := io.ReadFull(local, buf); err != nil || string(buf) != "ack" {
+					t.Fatalf("got %q, %v", buf, err)
+				}
+			},
+		},
+		{
+			name: "reset wakes a blocked writer and reader",
+			run: func(t *testing.T, client, server *Session) {
+				local, remote := openPair(t, client, server)
+				written := writeAsync(local, bytes.Repeat([]byte("x"), 2*StreamWindow))
+				read := make(chan error, 1)
+				go func() {
+					_, err := local.Read(make([]byte, 1))
+					read <- err
+				}()
+				time.Sleep(50 * time.Millisecond)
+				if err := remote.Reset(); err != nil {
+					t.Fatal(err)
+				}
+				for _, ch := range []<-chan error{written, read} {
+					if err := <-ch; !errors.Is(err, ErrStreamReset) {
+						t.Fatalf("got %v, want ErrStreamReset", err)
+					}
+				}
+				if _, err := remote.Read(make([]byte, 1)); !errors.Is(err, ErrStreamReset) {
+					t.Fatalf("got %v, want ErrStreamReset", err)
+				}
+			},
+		},
+		{
+			name: "closing the session fails open streams",
+			run: func(t *testing.T, client, server *Session) {
+				local, _ := openPair(t, client, server)
+				read := make(chan error, 1)
+				go func() {
+					_, err := local.Read(make([]byte, 1))
+					read <- err
+				}()
+				server.Close()
+				if err := <-read; !errors.Is(err, ErrSessionClosed) {
+					t.Fatalf("got %v, want ErrSessionClosed", err)
+				}
+				if _, err := client.Open(); err == nil {
+					t.Fatal("Open succeeded on a closed session")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := sessionPair(t)
+			tt.run(t, client, server)
+		})
+	}
+}
+
+func TestSessionResetsWindowViolation(t *testing.T) {
+	a, b := net.Pipe()
+	server := NewServerSession(NewCodecRegistry(), a)
+	defer server.Close()
+	peer := newRawSessionPeer(b)
+
+	peer.send(t, streamFrame{Kind: streamOpen, StreamID: 1})
+	for sent := 0; sent <= StreamWindow; sent += maxStreamChunk {
+		peer.send(t, streamFrame{Kind: streamData, StreamID: 1, Data: make([]byte, maxStreamChunk)})
+	}
+	if f := peer.next(t); f.Kind != streamReset || f.StreamID != 1 {
+		t.Fatalf("got frame kind %d for stream %d, want a reset of stream 1", f.Kind, f.StreamID)
+	}
+	st, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(st); !errors.Is(err, ErrStreamReset) {
+		t.Fatalf("got %v, want ErrStreamReset", err)
+	}
+}
@@ -0,0 +1,238 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"reflect"
+)
+
+// isSetType reports whether t is a map[K]struct{}, which resolveType encodes as a set.
+func isSetType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// SetCodec handles map[K]struct{} types as sets.
+// It stores the count of keys as a uvarint followed by each key's length (uvarint)
+// and encoded data. Values carry no information and are not written.
+type SetCodec struct {
+	keyCodec Codec
+	keyType  reflect.Type
+	setType  reflect.Type
+	codecOptions
+}
+
+func (c *SetCodec) Encode(value interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("SetCodec expects a map, got %T", value)
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(rv.Len()))
+	for _, keyVal := range rv.MapKeys() {
+		key := keyVal.Interface()
+		keyData, err := c.keyCodec.Encode(key)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding set key %v: %w", key, err)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(keyData)))
+		buf = append(buf, keyData...)
+	}
+	return buf, nil
+}
+
+func (c *SetCodec) Decode(data []byte) (interface{}, error) {
+	reader := bytes.NewReader(data)
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read set count: %w", err)
+	}
+	if err := c.opts.checkUvarint(count, len(data)-reader.Len(), "set count"); err != nil {
+		return nil, err
+	}
+	if count > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid set count %d for %d bytes of data", count, len(data))
+	}
+
+	m := reflect.MakeMapWithSize(c.setType, int(count))
+	present := reflect.New(c.setType.Elem()).Elem()
+	for i := 0; i < int(count); i++ {
+		before := reader.Len()
+		keyLen, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read set key %d length: %w", i, err)
+		}
+		if err := c.opts.checkUvarint(keyLen, before-reader.Len(), "set key length"); err != nil {
+			return nil, err
+		}
+		if keyLen > uint64(reader.Len()) {
+			return nil, fmt.Errorf("failed to read set key %d data: %w", i, io.ErrUnexpectedEOF)
+		}
+		keyData := make([]byte, keyLen)
+		if _, err := io.ReadFull(reader, keyData); err != nil {
+			return nil, fmt.Errorf("failed to read set key %d data: %w", i, err)
+		}
+
+		keyVal, err := c.keyCodec.Decode(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode set key %d: %w", i, err)
+		}
+		keyRv := reflect.ValueOf(keyVal)
+		if keyRv.Type().ConvertibleTo(c.keyType) {
+			keyRv = keyRv.Convert(c.keyType)
+		}
+		if c.opts.isStrict() && m.MapIndex(keyRv).IsValid() {
+			return nil, fmt.Errorf("duplicate set key %v", keyVal)
+		}
+		m.SetMapIndex(keyRv, present)
+	}
+
+	if err := c.opts.checkTrailing(reader.Len(), "set"); err != nil {
+		return nil, err
+	}
+	return m.Interface(), nil
+}
+
+// packBits appends n bits, eight per byte with bit i stored in byte i/8 at position i%8.
+func packBits(buf []byte, n int, bit func(i int) bool) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, (n+7)/8)...)
+	for i := 0; i < n; i++ {
+		if bit(i) {
+			buf[start+i/8] |= 1 << (i % 8)
+		}
+	}
+	return buf
+}
+
+// readPackedBits reads the uvarint bit count that prefixes a packed bit payload and
+// returns it together with the packed bytes. In strict mode the count must be minimal
+// and the padding bits of the last byte must be zero.
+func readPackedBits(data []byte, name string, opts *decodeOptions) (int, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 {
+		return 0, nil, fmt.Errorf("invalid %s bit count", name)
+	}
+	if err := opts.checkUvarint(n, size, name+" bit count"); err != nil {
+		return 0, nil, err
+	}
+	packed := data[size:]
+	if n > uint64(len(packed))*8 || uint64(len(packed)) != (n+7)/8 {
+		return 0, nil, fmt.Errorf("invalid data length for %s: %d bits in %d bytes", name, n, len(packed))
+	}
+	if opts.isStrict() && n%8 != 0 && packed[len(packed)-1]>>(n%8) != 0 {
+		return 0, nil, fmt.Errorf("invalid %s padding: unused bits are set", name)
+	}
+	return int(n), packed, nil
+}
+
+// BoolSliceCodec handles []bool as a uvarint element count followed by the
+// elements packed eight per byte.
+type BoolSliceCodec struct {
+	codecOptions
+}
+
+func (c *BoolSliceCodec) Encode(value interface{}) ([]byte, error) {
+	bools, ok := value.([]bool)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not []bool", value)
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(bools)))
+	return packBits(buf, len(bools), func(i int) bool { return bools[i] }), nil
+}
+
+func (c *BoolSliceCodec) Decode(data []byte) (interface{}, error) {
+	n, packed, err := readPackedBits(data, "[]bool", c.opts)
+	if err != nil {
+		return nil, err
+	}
+	bools := make([]bool, n)
+	for i := range bools {
+		bools[i] = packed[i/8]&(1<<(i%8)) != 0
+	}
+	return bools, nil
+}
+
+// Bitset is a fixed-width set of bits, numbered from 0 to Len()-1.
+// The zero value has width 0; use NewBitset to create one.
+type Bitset struct {
+	words []uint64
+	width int
+}
+
+// NewBitset returns a Bitset of the given width with all bits cleared.
+func NewBitset(width int) Bitset {
+	if width < 0 {
+		panic(fmt.Sprintf("NewBitset: negative width %d", width))
+	}
+	return Bitset{words: make([]uint64, (width+63)/64), width: width}
+}
+
+// Len returns the width of the bitset.
+func (b Bitset) Len() int {
+	return b.width
+}
+
+// Test reports whether bit i is set.
+func (b Bitset) Test(i int) bool {
+	b.check(i)
+	return b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Set sets bit i.
+func (b Bitset) Set(i int) {
+	b.check(i)
+	b.words[i/64] |= 1 << (i % 64)
+}
+
+// Clear clears bit i.
+func (b Bitset) Clear(i int) {
+	b.check(i)
+	b.words[i/64] &^= 1 << (i % 64)
+}
+
+// Count returns the number of set bits.
+func (b Bitset) Count() int {
+	count := 0
+	for _, w := range b.words {
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+func (b Bitset) check(i int) {
+	if i < 0 || i >= b.width {
+		panic(fmt.Sprintf("Bitset: index %d out of range [0, %d)", i, b.width))
+	}
+}
+
+// BitsetCodec handles Bitset as a uvarint width followed by the bits packed eight per byte.
+type BitsetCodec struct {
+	codecOptions
+}
+
+func (c *BitsetCodec) Encode(value interface{}) ([]byte, error) {
+	b, ok := value.(Bitset)
+	if !ok {
+		return nil, fmt.Errorf("value %v is not Bitset", value)
+	}
+	buf := binary.AppendUvarint(nil, uint64(b.width))
+	return packBits(buf, b.width, b.Test), nil
+}
+
+func (c *BitsetCodec) Decode(data []byte) (interface{}, error) {
+	n, packed, err := readPackedBits(data, "Bitset", c.opts)
+	if err != nil {
+		return nil, err
+	}
+	b := NewBitset(n)
+	for i := 0; i < n; i++ {
+		if packed[i/8]&(1<<(i%8)) != 0 {
+			b.Set(i)
+		}
+	}
+	return b, nil
+}
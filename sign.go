@@ -0,0 +1,150 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// MACSize is the length of the truncated HMAC-SHA256 carried by signed frames.
+const MACSize = 16
+
+// DefaultReplayWindow is the number of sequence numbers below the highest one seen
+// that a Decoder still accepts, once each, from a reordering transport.
+const DefaultReplayWindow = 64
+
+// MACKeyLookup returns the HMAC key with the given ID. It lets decoders verify frames
+// signed with any key that is currently valid, so keys can be rotated without
+// dropping connections.
+type MACKeyLookup func(keyID byte) (key []byte, ok bool)
+
+// ReplayedFrameError is returned by Decoder for a correctly signed frame whose
+// sequence number was already accepted or is too old for the replay window.
+type ReplayedFrameError struct {
+	Tag      byte
+	Sequence uint64
+}
+
+func (e *ReplayedFrameError) Error() string {
+	return fmt.Sprintf("replayed frame (tag %d): sequence number %d already seen or outside the replay window", e.Tag, e.Sequence)
+}
+
+// The trailer of a signed frame sits between the payload and EOF: the key ID, the
+// sequence number (8 bytes) and the MAC.
+const macTrailerSize = 1 + 8 + MACSize
+
+// frameMAC computes the truncated HMAC-SHA256 over the session ID, the frame header,
+// the payload, the key ID and the sequence number.
+func frameMAC(key, session []byte, flags FrameFlags, tag byte, payload []byte, keyID byte, seq uint64) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(binary.AppendUvarint(nil, uint64(len(session))))
+	mac.Write(session)
+	mac.Write(frameAD(flags, tag, len(payload)))
+	mac.Write(payload)
+	mac.Write([]byte{keyID})
+	mac.Write(binary.BigEndian.AppendUint64(nil, seq))
+	return mac.Sum(nil)[:MACSize]
+}
+
+// replayWindow tracks the highest sequence number accepted and which of the size
+// numbers below it have been seen.
+type replayWindow struct {
+	size    int
+	highest uint64
+	seen    uint64 // bit i set: highest-i was accepted
+}
+
+// accept records seq and reports whether it is new and within the window.
+func (w *replayWindow) accept(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.highest {
+		if shift := seq - w.highest; shift < 64 {
+			w.seen = w.seen<<shift | 1
+		} else {
+			w.seen = 1
+		}
+		w.highest = seq
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= uint64(w.size) || w.seen&(1<<diff) != 0 {
+		return false
+	}
+	w.seen |= 1 << diff
+	return true
+}
+
+// SetSigningKey makes the encoder sign every frame with a truncated HMAC-SHA256 under
+// key, identified on the wire by keyID, and number frames with a sequence number that
+// starts at 1. Signed frames use the versioned frame header with FlagChecksummed set.
+// The MAC covers the header and the payload as sent, after compression and
+// encryption. A nil key disables signing.
+//
+// Sequence numbers restart at 1 for every Encoder, so frames signed on one connection
+// would verify on any other using the same key. Call SetSession on both ends so that
+// they only verify on their own connection.
+func (e *Encoder) SetSigningKey(keyID byte, key []byte) {
+	e.macKeyID, e.macKey = keyID, key
+}
+
+// SetSession binds the frames the encoder signs to one connection. The session ID is
+// covered by the MAC but not sent, so a frame only verifies on a Decoder with the same
+// session. Both ends must agree on an ID that is unique to the connection, such as
+// HandshakeResult.SessionID.
+func (e *Encoder) SetSession(id []byte) {
+	e.session = bytes.Clone(id)
+}
+
+// SetSession makes the decoder verify frames for the given session only; see
+// Encoder.SetSession. It also starts a fresh replay window, since sequence numbers
+// restart with every session.
+func (d *Decoder) SetSession(id []byte) {
+	d.session = bytes.Clone(id)
+	if d.replay != nil {
+		d.replay = &replayWindow{size: d.replay.size}
+	}
+}
+
+// SetVerification makes the decoder verify signed frames with keys from lookup and
+// reject frames that are unsigned, fail verification (*TamperedFrameError), or whose
+// sequence number was already seen or lies more than window numbers below the
+// highest one accepted (*ReplayedFrameError). The window is at most 64; zero means
+// DefaultReplayWindow. A nil lookup disables verification.
+//
+// The replay window only covers this decoder. Unless each connection uses its own key,
+// set a session with SetSession, or frames captured on an earlier connection can be
+// replayed on this one.
+func (d *Decoder) SetVerification(lookup MACKeyLookup, window int) {
+	if window <= 0 || window > 64 {
+		window = DefaultReplayWindow
+	}
+	d.macLookup = lookup
+	d.replay = &replayWindow{size: window}
+}
+
+// sign returns the trailer for a frame with the given header and payload.
+func (e *Encoder) sign(flags FrameFlags, tag byte, payload []byte) []byte {
+	e.sequence++
+	trailer := binary.BigEndian.AppendUint64([]byte{e.macKeyID}, e.sequence)
+	return append(trailer, frameMAC(e.macKey, e.session, flags, tag, payload, e.macKeyID, e.sequence)...)
+}
+
+// verify checks the trailer of a signed frame against its header and payload.
+func (d *Decoder) verify(flags FrameFlags, tag byte, payload, trailer []byte) error {
+	keyID, seq := trailer[0], binary.BigEndian.Uint64(trailer[1:9])
+	key, ok := d.macLookup(keyID)
+	if !ok {
+		return fmt.Errorf("frame with tag %d is signed with unknown key %d", tag, keyID)
+	}
+	if !hmac.Equal(trailer[9:], frameMAC(key, d.session, flags, tag, payload, keyID, seq)) {
+		return &TamperedFrameError{Tag: tag, KeyID: keyID, Reason: "MAC mismatch"}
+	}
+	if !d.replay.accept(seq) {
+		return &ReplayedFrameError{Tag: tag, Sequence: seq}
+	}
+	return nil
+}
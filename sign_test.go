@@ -0,0 +1,113 @@
+package CryoDecoder
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+// Outcomes of decoding a signed frame.
+const (
+	signedOK       = "ok"
+	signedReplayed = "replayed"
+	signedTampered = "tampered"
+	signedRejected = "rejected" // any other error
+)
+
+func TestSignedFrames(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	lookup := func(id byte) ([]byte, bool) { return key, id == 1 }
+
+	tests := []struct {
+		name        string
+		keyID       byte   // key the sender signs with; 0 sends unsigned frames
+		sendSession []byte // session bound by the sender
+		recvSession []byte // session bound by the receiver
+		window      int
+		frames      int   // frames signed, numbered from 0
+		deliver     []int // frames delivered, in order
+		tamper      int   // index into deliver of a frame to modify, or -1
+		want        []string
+	}{
+		{name: "in order", keyID: 1, frames: 3, deliver: []int{0, 1, 2}, tamper: -1, want: []string{signedOK, signedOK, signedOK}},
+		{name: "reordered within the window", keyID: 1, frames: 3, deliver: []int{2, 0, 1}, tamper: -1, want: []string{signedOK, signedOK, signedOK}},
+		{name: "replayed", keyID: 1, frames: 2, deliver: []int{0, 1, 0}, tamper: -1, want: []string{signedOK, signedOK, signedReplayed}},
+		{
+			name: "below the window", keyID: 1, window: 4, frames: 6, deliver: []int{5, 1, 2}, tamper: -1,
+			want: []string{signedOK, signedReplayed, signedOK},
+		},
+		{name: "modified payload", keyID: 1, frames: 2, deliver: []int{0, 1}, tamper: 0, want: []string{signedTampered, signedOK}},
+		{name: "unknown key", keyID: 2, frames: 1, deliver: []int{0}, tamper: -1, want: []string{signedRejected}},
+		{name: "unsigned", frames: 1, deliver: []int{0}, tamper: -1, want: []string{signedRejected}},
+		{
+			name: "same session", keyID: 1, sendSession: []byte("session A"), recvSession: []byte("session A"),
+			frames: 1, deliver: []int{0}, tamper: -1, want: []string{signedOK},
+		},
+		{
+			name: "other session", keyID: 1, sendSession: []byte("session A"), recvSession: []byte("session B"),
+			frames: 1, deliver: []int{0}, tamper: -1, want: []string{signedTampered},
+		},
+		{
+			name: "session only on the sender", keyID: 1, sendSession: []byte("session A"),
+			frames: 1, deliver: []int{0}, tamper: -1, want: []string{signedTampered},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.RegisterPrimitives()
+			encoder := NewEncoder(registry)
+			if tt.keyID != 0 {
+				encoder.SetSigningKey(tt.keyID, key)
+			}
+			encoder.SetSession(tt.sendSession)
+			frames := make([][]byte, tt.frames)
+			for i := range frames {
+				frame, err := encoder.Encode(int32(i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				frames[i] = frame
+			}
+
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+			go func() {
+				for i, n := range tt.deliver {
+					frame := bytes.Clone(frames[n])
+					if i == tt.tamper {
+						frame[len(frame)-2-macTrailerSize] ^= 1 // the last payload byte
+					}
+					if _, err := a.Write(frame); err != nil {
+						return
+					}
+				}
+			}()
+
+			decoder := NewDecoder(registry, b)
+			decoder.SetVerification(lookup, tt.window)
+			decoder.SetSession(tt.recvSession)
+			for i, n := range tt.deliver {
+				got, err := decoder.Decode()
+				var replayed *ReplayedFrameError
+				var tampered *TamperedFrameError
+				outcome := signedOK
+				switch {
+				case errors.As(err, &replayed):
+					outcome = signedReplayed
+				case errors.As(err, &tampered):
+					outcome = signedTampered
+				case err != nil:
+					outcome = signedRejected
+				case got != int32(n):
+					t.Fatalf("frame %d decoded as %v", n, got)
+				}
+				if outcome != tt.want[i] {
+					t.Errorf("delivery %d (frame %d): got %s (%v), want %s", i, n, outcome, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package CryoDecoder
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// timePayload builds a TimeCodec payload with a zero UTC offset.
+func timePayload(precision TimePrecision, sec int64, frac uint64) []byte {
+	data := binary.AppendVarint([]byte{byte(precision)}, sec)
+	if precision != TimeSeconds {
+		data = binary.AppendUvarint(data, frac)
+	}
+	return binary.AppendVarint(data, 0)
+}
+
+func TestTimeCodecDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		data    []byte
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "nanoseconds", data: timePayload(TimeNanoseconds, 10, 999_999_999), want: time.Unix(10, 999_999_999)},
+		{name: "milliseconds", data: timePayload(TimeMilliseconds, 10, 999), want: time.Unix(10, 999_000_000)},
+		{name: "one second of nanoseconds", data: timePayload(TimeNanoseconds, 10, 1_000_000_000), wantErr: true},
+		{name: "one second of milliseconds", data: timePayload(TimeMilliseconds, 10, 1000), wantErr: true},
+		{name: "nanoseconds above MaxInt64", data: timePayload(TimeNanoseconds, 10, 1<<63), wantErr: true},
+		{name: "milliseconds wrapping to a negative value", data: timePayload(TimeMilliseconds, 10, math.MaxUint64/1_000_000), wantErr: true},
+		{
+			name:    "non-minimal varint in strict mode",
+			strict:  true,
+			data:    append(append([]byte{byte(TimeSeconds)}, 0x94, 0x00), 0),
+			wantErr: true,
+		},
+		{name: "non-minimal varint", data: append(append([]byte{byte(TimeSeconds)}, 0x94, 0x00), 0), want: time.Unix(10, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.SetStrict(tt.strict)
+			codec := NewTimeCodec(TimeNanoseconds, false)
+			registry.RegisterCodec(21, codec, time.Time{})
+
+			got, err := codec.Decode(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.(time.Time).Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cryodecoder_tzdata
+
+package CryoDecoder
+
+// Importing time/tzdata embeds the IANA time zone database in the binary, so
+// LocationCodec can decode named zones on hosts without a system zoneinfo.
+import _ "time/tzdata"